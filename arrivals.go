@@ -0,0 +1,37 @@
+package synthetic_load
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Draws the time from an arrival at now to the next one, for a trace of the
+// given average rate.
+type ArrivalProcess func(rng *rand.Rand, qps float64, now time.Duration) time.Duration
+
+// Poisson arrivals, the default: the time between arrivals is exponentially
+// distributed.
+func PoissonArrivals() ArrivalProcess {
+	return func(rng *rand.Rand, qps float64, now time.Duration) time.Duration {
+		return time.Duration((rng.ExpFloat64() / qps) * float64(time.Second))
+	}
+}
+
+// Evenly spaced arrivals.
+func ConstantArrivals() ArrivalProcess {
+	return func(rng *rand.Rand, qps float64, now time.Duration) time.Duration {
+		return seconds(1 / qps)
+	}
+}
+
+// Poisson arrivals whose rate is multiplied by factor from start for length,
+// such as a tenant's traffic spike.
+func SpikeArrivals(factor float64, start, length time.Duration) ArrivalProcess {
+	poisson := PoissonArrivals()
+	return func(rng *rand.Rand, qps float64, now time.Duration) time.Duration {
+		if now >= start && now < start+length {
+			qps *= factor
+		}
+		return poisson(rng, qps, now)
+	}
+}
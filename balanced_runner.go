@@ -0,0 +1,230 @@
+package synthetic_load
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/seehuhn/mt19937"
+)
+
+// How a BalancedRunner picks the target of a query.
+type BalancePolicy int
+
+const (
+	// Each target in turn.
+	BalanceRoundRobin BalancePolicy = iota
+	// A target chosen uniformly at random.
+	BalanceRandom
+	// The target with the fewest queries in flight.
+	BalanceLeastOutstanding
+	// The less loaded of two targets chosen at random.
+	BalancePowerOfTwo
+	// The target owning the query's InputIndex on a consistent hash ring, so
+	// that an input is always served by the same target.
+	BalanceConsistentHash
+)
+
+// A named runner a BalancedRunner spreads queries over.
+type Target struct {
+	Name   string
+	Runner ContextRunner
+}
+
+// A ContextRunner spreading queries over several targets, such as the
+// replicas of a service. Every completion carries the name of the target that
+// served it, so that ReplayResult.ByTarget reports each target on its own.
+// The counters "balance.<name>.queries" give the number of queries sent to
+// each target.
+type BalancedRunner struct {
+	targets     []Target
+	policy      BalancePolicy
+	seed        int64
+	replicas    int
+	outstanding []int64
+	next        uint64
+	ring        []ringPoint
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	counters map[string]int64
+}
+
+type BalanceOption func(*BalancedRunner)
+
+// The seed of the random and power of two choices policies.
+func BalanceSeed(seed int64) BalanceOption {
+	return func(r *BalancedRunner) {
+		r.seed = seed
+	}
+}
+
+// The number of points of each target on the consistent hash ring.
+func BalanceReplicas(n int) BalanceOption {
+	return func(r *BalancedRunner) {
+		r.replicas = n
+	}
+}
+
+type ringPoint struct {
+	hash   uint32
+	target int
+}
+
+func NewBalancedRunner(policy BalancePolicy, targets []Target, opts ...BalanceOption) (*BalancedRunner, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("balanced runner needs at least one target")
+	}
+
+	r := &BalancedRunner{
+		targets:     targets,
+		policy:      policy,
+		replicas:    128,
+		outstanding: make([]int64, len(targets)),
+		counters:    map[string]int64{},
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	if r.replicas < 1 {
+		return nil, errors.New("balanced runner needs at least one replica per target")
+	}
+
+	mt := mt19937.New()
+	mt.Seed(r.seed)
+	r.rng = rand.New(mt)
+
+	if policy == BalanceConsistentHash {
+		for ii, target := range targets {
+			for jj := 0; jj < r.replicas; jj++ {
+				r.ring = append(r.ring, ringPoint{
+					hash:   hashString(target.Name + "#" + strconv.Itoa(jj)),
+					target: ii,
+				})
+			}
+		}
+		sort.Slice(r.ring, func(ii, jj int) bool {
+			return r.ring[ii].hash < r.ring[jj].hash
+		})
+	}
+
+	return r, nil
+}
+
+func (r *BalancedRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	ii := r.pick(q)
+	target := r.targets[ii]
+
+	// a runner may both call back and fail
+	var once sync.Once
+	done := func() {
+		once.Do(func() {
+			atomic.AddInt64(&r.outstanding[ii], -1)
+		})
+	}
+
+	atomic.AddInt64(&r.outstanding[ii], 1)
+	err := target.Runner.RunContext(ctx, q, func(c Completion) {
+		done()
+		onFinish(r.tag(c, target))
+	})
+	if err != nil {
+		done()
+	}
+	return err
+}
+
+// Runs the query in virtual time. Targets that do not support virtual time
+// fail their queries.
+func (r *BalancedRunner) Simulate(sim *Simulator, q Query, onFinish func(Completion)) {
+	ii := r.pick(q)
+	target := r.targets[ii]
+
+	runner, ok := simulatedRunner(target.Runner)
+	if !ok {
+		onFinish(r.tag(Completion{Err: errors.New("the target does not support virtual time")}, target))
+		return
+	}
+	atomic.AddInt64(&r.outstanding[ii], 1)
+	runner.Simulate(sim, q, func(c Completion) {
+		atomic.AddInt64(&r.outstanding[ii], -1)
+		onFinish(r.tag(c, target))
+	})
+}
+
+func (r *BalancedRunner) Counters() map[string]int64 {
+	counters := map[string]int64{}
+	for _, target := range r.targets {
+		for name, value := range runnerCounters(target.Runner) {
+			counters[name] += value
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, value := range r.counters {
+		counters[name] += value
+	}
+	return counters
+}
+
+// Names the target in the completion, nested below the target's own target
+// if it is a balancer too.
+func (r *BalancedRunner) tag(c Completion, target Target) Completion {
+	if c.Target != "" {
+		c.Target = target.Name + "/" + c.Target
+	} else {
+		c.Target = target.Name
+	}
+	return c
+}
+
+func (r *BalancedRunner) pick(q Query) int {
+	ii := 0
+	switch r.policy {
+	case BalanceRandom:
+		ii = r.intn(len(r.targets))
+	case BalanceLeastOutstanding:
+		for jj := range r.targets {
+			if atomic.LoadInt64(&r.outstanding[jj]) < atomic.LoadInt64(&r.outstanding[ii]) {
+				ii = jj
+			}
+		}
+	case BalancePowerOfTwo:
+		a, b := r.intn(len(r.targets)), r.intn(len(r.targets))
+		ii = a
+		if atomic.LoadInt64(&r.outstanding[b]) < atomic.LoadInt64(&r.outstanding[a]) {
+			ii = b
+		}
+	case BalanceConsistentHash:
+		hash := hashString(strconv.Itoa(q.InputIndex))
+		jj := sort.Search(len(r.ring), func(jj int) bool {
+			return r.ring[jj].hash >= hash
+		})
+		ii = r.ring[jj%len(r.ring)].target
+	default:
+		ii = int((atomic.AddUint64(&r.next, 1) - 1) % uint64(len(r.targets)))
+	}
+
+	r.mu.Lock()
+	r.counters["balance."+r.targets[ii].Name+".queries"]++
+	r.mu.Unlock()
+
+	return ii
+}
+
+func (r *BalancedRunner) intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Intn(n)
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,105 @@
+package synthetic_load
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalancedRunnerReportsTargets(t *testing.T) {
+	for _, policy := range []BalancePolicy{
+		BalanceRoundRobin,
+		BalanceRandom,
+		BalanceLeastOutstanding,
+		BalancePowerOfTwo,
+		BalanceConsistentHash,
+	} {
+		runner, err := NewBalancedRunner(policy, []Target{
+			{Name: "fast", Runner: NewSimulatedServerRunner(4, Constant(0.005))},
+			{Name: "slow", Runner: NewSimulatedServerRunner(4, Constant(0.05))},
+		})
+		assert.NoError(t, err)
+
+		trace := NewTrace(QPS(50), MinDuration(10*time.Second), Seed(1))
+		result, err := trace.Measure(VirtualTime(), InputContextRunner(runner))
+		assert.NoError(t, err)
+		assert.Equal(t, len(trace), result.Completed())
+
+		targets := result.ByTarget()
+		assert.Len(t, targets, 2, "policy %d", policy)
+		assert.Equal(t, 5*time.Millisecond, targets["fast"].Percentile(0.5), "policy %d", policy)
+		assert.Equal(t, 50*time.Millisecond, targets["slow"].Percentile(0.5), "policy %d", policy)
+		assert.Equal(t, int64(len(targets["fast"].Queries)), result.Counters["balance.fast.queries"])
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	owners := func(names ...string) map[int]string {
+		targets := []Target{}
+		for _, name := range names {
+			targets = append(targets, Target{Name: name, Runner: AdaptRunner(SleepingRunner{})})
+		}
+		runner, err := NewBalancedRunner(BalanceConsistentHash, targets)
+		assert.NoError(t, err)
+		owners := map[int]string{}
+		for idx := 0; idx < 10000; idx++ {
+			owners[idx] = names[runner.pick(Query{TraceEntry: TraceEntry{InputIndex: idx}})]
+		}
+		return owners
+	}
+
+	before := owners("a", "b", "c", "d")
+	assert.Equal(t, before, owners("a", "b", "c", "d"))
+	shares := map[string]int{}
+	for _, name := range before {
+		shares[name]++
+	}
+	for name, n := range shares {
+		assert.InDelta(t, 0.25, float64(n)/10000, 0.1, name)
+	}
+
+	// adding a target only moves the keys it takes over, about 1/5 of them
+	moved := 0
+	for idx, name := range owners("a", "b", "c", "d", "e") {
+		if name != before[idx] {
+			assert.Equal(t, "e", name)
+			moved++
+		}
+	}
+	assert.InDelta(t, 0.2, float64(moved)/10000, 0.1)
+
+	// removing a target only moves its own keys
+	moved = 0
+	for idx, name := range owners("a", "b", "c") {
+		if name != before[idx] {
+			assert.Equal(t, "d", before[idx])
+			moved++
+		}
+	}
+	assert.Equal(t, shares["d"], moved)
+
+	_, err := NewBalancedRunner(BalanceConsistentHash, []Target{{Name: "a"}}, BalanceReplicas(0))
+	assert.Error(t, err)
+}
+
+func TestBalancedRunnerCountsFailuresOnce(t *testing.T) {
+	runner, err := NewBalancedRunner(BalanceLeastOutstanding, []Target{
+		{Name: "a", Runner: doubleFailingRunner{}},
+	})
+	assert.NoError(t, err)
+	err = runner.RunContext(context.Background(), Query{}, func(Completion) {})
+	assert.Error(t, err)
+	assert.EqualValues(t, 0, runner.outstanding[0])
+}
+
+// Calls back and then fails, like runners that report errors both ways.
+type doubleFailingRunner struct{}
+
+func (doubleFailingRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	err := errors.New("unavailable")
+	onFinish(Completion{Err: err})
+	return err
+}
@@ -0,0 +1,144 @@
+package synthetic_load
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Implemented by runners that serve several queries in a single call. The on
+// completion function receives one completion per query, in order.
+type BatchRunner interface {
+	RunBatch(context.Context, []Query, func([]Completion)) error
+}
+
+// A ContextRunner that accumulates queries until either the maximum batch size
+// is reached or the oldest query waited for the maximum wait, and then hands
+// them to an inner BatchRunner in one call.
+//
+// Every query reports the time it waited for its batch as the "batch_wait"
+// timing. The counters "batch.count" and "batch.size.<n>" give the number of
+// batches and the histogram of their sizes.
+type BatchingRunner struct {
+	inner        BatchRunner
+	maxBatchSize int
+	maxWait      time.Duration
+
+	mu         sync.Mutex
+	pending    []batchedQuery
+	timer      *time.Timer
+	generation uint64
+	counters   map[string]int64
+}
+
+type batchedQuery struct {
+	ctx      context.Context
+	query    Query
+	onFinish func(Completion)
+	arrival  time.Time
+}
+
+func NewBatchingRunner(inner BatchRunner, maxBatchSize int, maxWait time.Duration) *BatchingRunner {
+	return &BatchingRunner{
+		inner:        inner,
+		maxBatchSize: maxBatchSize,
+		maxWait:      maxWait,
+		counters:     map[string]int64{},
+	}
+}
+
+func (r *BatchingRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	r.mu.Lock()
+	r.pending = append(r.pending, batchedQuery{
+		ctx:      ctx,
+		query:    q,
+		onFinish: onFinish,
+		arrival:  time.Now(),
+	})
+	if len(r.pending) >= r.maxBatchSize || r.maxWait <= 0 {
+		batch := r.take()
+		r.mu.Unlock()
+		r.dispatch(batch)
+		return nil
+	}
+	if len(r.pending) == 1 {
+		generation := r.generation
+		r.timer = time.AfterFunc(r.maxWait, func() {
+			r.flush(generation)
+		})
+	}
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *BatchingRunner) Counters() map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counters := make(map[string]int64, len(r.counters))
+	for name, value := range r.counters {
+		counters[name] = value
+	}
+	return counters
+}
+
+// Dispatches the pending queries once the oldest of them waited long enough,
+// unless they were already dispatched as a full batch.
+func (r *BatchingRunner) flush(generation uint64) {
+	r.mu.Lock()
+	if generation != r.generation || len(r.pending) == 0 {
+		r.mu.Unlock()
+		return
+	}
+	batch := r.take()
+	r.mu.Unlock()
+	r.dispatch(batch)
+}
+
+// Takes the pending queries as a batch. Must be called with the lock held.
+func (r *BatchingRunner) take() []batchedQuery {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	r.generation++
+	batch := r.pending
+	r.pending = nil
+	r.counters["batch.count"]++
+	r.counters["batch.size."+strconv.Itoa(len(batch))]++
+	return batch
+}
+
+func (r *BatchingRunner) dispatch(batch []batchedQuery) {
+	dispatched := time.Now()
+	queries := make([]Query, len(batch))
+	for ii, b := range batch {
+		queries[ii] = b.query
+	}
+
+	finish := func(completions []Completion) {
+		for ii, b := range batch {
+			c := Completion{Err: errors.New("batch runner returned too few completions")}
+			if ii < len(completions) {
+				c = completions[ii]
+			}
+			timings := map[string]time.Duration{"batch_wait": dispatched.Sub(b.arrival)}
+			for name, t := range c.Timings {
+				timings[name] = t
+			}
+			c.Timings = timings
+			b.onFinish(c)
+		}
+	}
+
+	// the oldest query has the earliest deadline
+	if err := r.inner.RunBatch(batch[0].ctx, queries, finish); err != nil {
+		completions := make([]Completion, len(batch))
+		for ii := range completions {
+			completions[ii].Err = err
+		}
+		finish(completions)
+	}
+}
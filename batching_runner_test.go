@@ -0,0 +1,35 @@
+package synthetic_load
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves a batch in 10ms regardless of its size.
+type fixedBatchRunner struct{}
+
+func (fixedBatchRunner) RunBatch(ctx context.Context, qs []Query, onFinish func([]Completion)) error {
+	time.Sleep(10 * time.Millisecond)
+	onFinish(make([]Completion, len(qs)))
+	return nil
+}
+
+func TestBatchingRunner(t *testing.T) {
+	trace := burstTrace(10)
+	// a late straggler that has to wait for the timeout
+	trace = append(trace, TraceEntry{Index: 10, TimeStamp: 100 * time.Millisecond})
+
+	result, err := trace.Measure(
+		InputContextRunner(NewBatchingRunner(fixedBatchRunner{}, 4, 20*time.Millisecond)),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 11, result.Completed())
+	assert.Equal(t, int64(2), result.Counters["batch.size.4"])
+	assert.Equal(t, int64(1), result.Counters["batch.size.2"])
+	assert.Equal(t, int64(1), result.Counters["batch.size.1"])
+	assert.Equal(t, int64(4), result.Counters["batch.count"])
+	assert.True(t, result.TimingPercentile("batch_wait", 1) >= 20*time.Millisecond)
+}
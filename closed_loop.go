@@ -0,0 +1,289 @@
+package synthetic_load
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Throughput and latency measured at one closed-loop concurrency level.
+type ConcurrencyPoint struct {
+	Concurrency int
+	Throughput  float64
+	// Latency at the latency bound percentile.
+	Latency time.Duration
+	Errors  int
+}
+
+// Runs a fixed number of clients, each issuing its next query as soon as the
+// previous one completes (plus the think time), until both the minimum
+// duration and the minimum number of queries are reached. With VirtualTime the
+// clients run on the simulator's clock.
+func RunClosedLoop(opts ...Option) (*ReplayResult, error) {
+	options := NewOptions(opts...)
+
+	// Input indices are drawn like in NewTrace so that runs are reproducible
+	// for a given seed, up to the interleaving of the clients.
+	inputRng := inputRng(options.seed)
+
+	var classRng *rand.Rand
+	if options.workload != nil {
+		classRng = options.workload.rng(options.seed)
+	}
+	draw := func(index int) TraceEntry {
+		tr := TraceEntry{Index: index, InputIndex: inputRng.Int()}
+		if classRng != nil {
+			tr.Class = options.workload.pick(classRng)
+		}
+		return tr
+	}
+
+	// The inputs of the first MinQueries queries are generated up front, later
+	// ones on demand.
+	drawn := make(Trace, options.minQueries)
+	for ii := range drawn {
+		drawn[ii] = draw(ii)
+	}
+	inputs := newInputCache(options)
+	if err := inputs.prepare(drawn); err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	queries := []QueryResult{}
+	var now func() time.Duration
+	var traceStart time.Time
+
+	// On the simulator's clock, queries that take no time (or inputs that
+	// always fail without a think time) would keep the clients issuing at the
+	// same instant forever, so the run stops once more queries than the
+	// clients and the minimum number of queries are issued without the clock
+	// advancing.
+	maxStalled := options.concurrency + options.minQueries
+	stalled, stalledAt := 0, time.Duration(0)
+
+	next := func() (TraceEntry, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		elapsed := now()
+		if options.ctx.Err() != nil ||
+			(elapsed >= options.minDuration && len(queries) >= options.minQueries) {
+			return TraceEntry{}, false
+		}
+		if options.virtualTime {
+			if elapsed != stalledAt {
+				stalled, stalledAt = 0, elapsed
+			}
+			if stalled++; stalled > maxStalled {
+				return TraceEntry{}, false
+			}
+		}
+		var tr TraceEntry
+		if len(queries) < len(drawn) {
+			tr = drawn[len(queries)]
+		} else {
+			tr = draw(len(queries))
+		}
+		tr.TimeStamp = elapsed
+		options.schedule(&tr)
+		queries = append(queries, QueryResult{TraceEntry: tr})
+		return tr, true
+	}
+
+	record := func(tr TraceEntry, issued time.Duration, finished bool, c Completion) {
+		mu.Lock()
+		defer mu.Unlock()
+		q := &queries[tr.Index]
+		q.Issued = issued
+		if finished {
+			q.Latency = now() - issued
+			q.Finished = true
+		}
+		q.Err = c.Err
+		q.Timings = c.Timings
+		q.Target = c.Target
+	}
+
+	if options.virtualTime {
+		if _, ok := simulatedRunner(options.runner); !ok {
+			return nil, errors.New("the runner does not support virtual time")
+		}
+		sim := &Simulator{}
+		now = sim.Now
+
+		var client func()
+		client = func() {
+			tr, ok := next()
+			if !ok {
+				return
+			}
+			// the next query of the client follows the think time
+			again := func() {
+				sim.After(options.thinkTime, client)
+			}
+			input, err := inputs.get(tr)
+			if err != nil {
+				record(tr, now(), false, Completion{Err: err})
+				again()
+				return
+			}
+			issued := now()
+			simulateQuery(sim, options, Query{TraceEntry: tr, Input: input}, func(c Completion) {
+				record(tr, issued, true, c)
+				again()
+			})
+		}
+		for ii := 0; ii < options.concurrency; ii++ {
+			sim.At(0, client)
+		}
+		sim.run(func() bool {
+			return options.ctx.Err() != nil
+		})
+		if err := options.ctx.Err(); err != nil {
+			return nil, err
+		}
+		if stalled > maxStalled {
+			return nil, errors.New("the virtual clock does not advance")
+		}
+	} else {
+		traceStart = time.Now()
+		now = func() time.Duration {
+			return time.Since(traceStart)
+		}
+
+		client := func() {
+			for {
+				tr, ok := next()
+				if !ok {
+					return
+				}
+				input, err := inputs.get(tr)
+				if err != nil {
+					record(tr, now(), false, Completion{Err: err})
+					continue
+				}
+				finished := make(chan struct{})
+				issued := now()
+				err = runQuery(
+					options.ctx,
+					options,
+					Query{TraceEntry: tr, Input: input, TraceStart: traceStart},
+					func(c Completion) {
+						record(tr, issued, true, c)
+						close(finished)
+					},
+				)
+				if err != nil {
+					record(tr, issued, false, Completion{Err: err})
+				} else {
+					<-finished
+				}
+				if options.thinkTime > 0 {
+					time.Sleep(options.thinkTime)
+				}
+			}
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(options.concurrency)
+		for ii := 0; ii < options.concurrency; ii++ {
+			go func() {
+				defer wg.Done()
+				client()
+			}()
+		}
+		wg.Wait()
+	}
+
+	result := &ReplayResult{
+		Queries:  queries,
+		Duration: now(),
+	}
+
+	trace := make(Trace, len(queries))
+	for ii, q := range queries {
+		trace[ii] = q.TraceEntry
+	}
+	trace.excludeWindows(result, options)
+
+	return result, nil
+}
+
+// Returns the closed-loop concurrency with the highest throughput subject to
+// the latency bound, along with every level measured during the search. A
+// level with errors fails the bound. The concurrency is doubled until the
+// latency bound is exceeded and then bisected.
+func FindMaxConcurrency(opts ...Option) (int, []ConcurrencyPoint) {
+	options := NewOptions(opts...)
+
+	points := []ConcurrencyPoint{}
+	// whether the level meets the latency bound without errors
+	meets := map[int]bool{}
+	measure := func(concurrency int) (bool, bool) {
+		options.seed += 1
+		result, err := RunClosedLoop(append(opts, Seed(options.seed), Concurrency(concurrency))...)
+		if err != nil {
+			return false, false
+		}
+		point := ConcurrencyPoint{
+			Concurrency: concurrency,
+			Throughput:  result.Throughput(),
+			Latency:     result.Percentile(options.latencyBoundPercentile),
+			Errors:      result.Errors(),
+		}
+		points = append(points, point)
+		meets[concurrency] = point.Errors == 0 && options.meetsLatencyBound(result)
+		log.WithField("concurrency", concurrency).
+			WithField("throughput", point.Throughput).
+			WithField("% latency", point.Latency).
+			WithField("errors", point.Errors).
+			Info("ran closed loop")
+		return meets[concurrency], true
+	}
+
+	iters := int64(0)
+	// the largest concurrency meeting the bound and the smallest exceeding it
+	lower, upper := 0, 0
+
+	for concurrency := 1; concurrency <= options.maxConcurrency && iters < options.maxQpsSearchIterations; concurrency *= 2 {
+		iters++
+		met, ok := measure(concurrency)
+		if !ok {
+			break
+		}
+		if !met {
+			upper = concurrency
+			break
+		}
+		lower = concurrency
+	}
+
+	for upper-lower > 1 && iters < options.maxQpsSearchIterations {
+		iters++
+		concurrency := (lower + upper) / 2
+		met, ok := measure(concurrency)
+		if !ok {
+			break
+		}
+		if !met {
+			upper = concurrency
+		} else {
+			lower = concurrency
+		}
+	}
+
+	sort.Slice(points, func(ii, jj int) bool {
+		return points[ii].Concurrency < points[jj].Concurrency
+	})
+
+	best, bestThroughput := 0, 0.0
+	for _, point := range points {
+		if meets[point.Concurrency] && point.Throughput > bestThroughput {
+			best, bestThroughput = point.Concurrency, point.Throughput
+		}
+	}
+
+	return best, points
+}
@@ -0,0 +1,96 @@
+package synthetic_load
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunClosedLoop(t *testing.T) {
+	result, err := RunClosedLoop(
+		Concurrency(4),
+		MinDuration(200*time.Millisecond),
+		MinQueries(8),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Errors())
+	assert.InDelta(t, 200, result.Throughput(), 40)
+	assert.True(t, result.Percentile(0.5) >= 20*time.Millisecond)
+}
+
+func TestFindMaxConcurrency(t *testing.T) {
+	// With no think time the servers are busy once there are as many clients
+	// as servers, and every further client only waits for a completion first.
+	// At 100 QPS per server the 90th percentile is 23ms with up to 4 clients
+	// and 26ms with 5.
+	serviceRate := 100.0
+	concurrency, points := FindMaxConcurrency(
+		VirtualTime(),
+		InputContextRunner(NewSimulatedServerRunner(4, Exponential(1/serviceRate), SimulatedSeed(1))),
+		InputGenerator(func(idx int) ([]byte, error) {
+			return nil, nil
+		}),
+		LatencyBound(25*time.Millisecond),
+		LatencyBoundPercentile(0.9),
+		MinDuration(2*time.Minute),
+		MinQueries(1),
+	)
+	assert.Equal(t, 4, concurrency)
+
+	expected := MMc{Servers: 4, ServiceRate: serviceRate}.Percentile(0.9)
+	for _, point := range points {
+		assert.Equal(t, 0, point.Errors)
+		if point.Concurrency <= 4 {
+			assert.InEpsilon(t, float64(point.Concurrency)*serviceRate, point.Throughput, 0.02)
+			assert.InEpsilon(t, float64(expected), float64(point.Latency), 0.05)
+		} else {
+			assert.True(t, point.Latency > 25*time.Millisecond, "latency %v", point.Latency)
+		}
+	}
+}
+
+func TestRunClosedLoopVirtualTimeStalls(t *testing.T) {
+	opts := []Option{
+		VirtualTime(),
+		Concurrency(2),
+		InputGenerator(func(idx int) ([]byte, error) {
+			return nil, nil
+		}),
+		MinDuration(time.Second),
+		MinQueries(8),
+	}
+
+	// queries that take no time never advance the clock
+	_, err := RunClosedLoop(append(opts,
+		InputContextRunner(NewSimulatedServerRunner(1, Constant(0), SimulatedSeed(1))),
+	)...)
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = RunClosedLoop(append(opts,
+		InputContextRunner(NewSimulatedServerRunner(1, Constant(0.010), SimulatedSeed(1))),
+		Context(ctx),
+	)...)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestRunClosedLoopInputIndices(t *testing.T) {
+	result, err := RunClosedLoop(
+		VirtualTime(),
+		InputContextRunner(NewSimulatedServerRunner(1, Constant(0.010), SimulatedSeed(1))),
+		InputGenerator(func(idx int) ([]byte, error) {
+			return nil, nil
+		}),
+		MinDuration(0),
+		MinQueries(4),
+		Seed(3),
+	)
+	assert.NoError(t, err)
+	trace := NewTrace(MinDuration(0), MinQueries(4), Seed(3))
+	for ii, q := range result.Queries {
+		assert.Equal(t, trace[ii].InputIndex, q.InputIndex)
+	}
+}
@@ -0,0 +1,360 @@
+package synthetic_load
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/seehuhn/mt19937"
+)
+
+// Adjusts the offered rate once per control interval from the latency measured
+// at the latency bound percentile during the interval.
+type RateController interface {
+	Next(qps float64, latency, bound time.Duration) float64
+}
+
+type aimdController struct {
+	increase float64
+	decrease float64
+}
+
+// Additive increase, multiplicative decrease: the rate grows by increase QPS
+// while the latency meets the bound and is multiplied by decrease otherwise.
+func AIMD(increase, decrease float64) RateController {
+	return &aimdController{increase: increase, decrease: decrease}
+}
+
+func (c *aimdController) Next(qps float64, latency, bound time.Duration) float64 {
+	if latency <= bound {
+		return qps + c.increase
+	}
+	return qps * c.decrease
+}
+
+type pidController struct {
+	kp, ki, kd float64
+	integral   float64
+	previous   float64
+	started    bool
+}
+
+// A PID controller acting on the relative error between the bound and the
+// latency. The rate changes by at most a factor of two per interval.
+func PID(kp, ki, kd float64) RateController {
+	return &pidController{kp: kp, ki: ki, kd: kd}
+}
+
+func (c *pidController) Next(qps float64, latency, bound time.Duration) float64 {
+	e := math.Max(-1, math.Min(1, float64(bound-latency)/float64(bound)))
+	c.integral += e
+	derivative := 0.0
+	if c.started {
+		derivative = e - c.previous
+	}
+	c.previous, c.started = e, true
+
+	u := c.kp*e + c.ki*c.integral + c.kd*derivative
+	return qps * math.Max(0.5, math.Min(2, 1+u))
+}
+
+// The offered rate and measured latency of one control interval.
+type ControlSample struct {
+	// Offset of the end of the interval from the start of the run.
+	Time time.Duration
+	// The rate offered during the interval.
+	QPS float64
+	// The latency at the latency bound percentile of the queries that
+	// completed during the interval. A query outstanding for longer than the
+	// latency bound counts as infinitely slow, once.
+	Latency time.Duration
+	Queries int
+	// The workload class furthest from its SLO, whose latency the controller
+	// acted on.
+	Class string
+}
+
+// The outcome of a controlled run.
+type ControlResult struct {
+	// The rate the controller converged to, the mean offered rate over the
+	// last quarter of the run.
+	QPS     float64
+	Samples []ControlSample
+	Result  *ReplayResult
+}
+
+// Runs a single trace for the minimum duration whose rate the controller
+// adjusts every control interval to hold the latency bound percentile at the
+// latency bound. The run starts at QPS, or at the sweep's start rate. Unlike
+// FindMaxQPS it needs no separate traces, so that a service warms up only once
+// and drifts in its capacity show in the samples.
+func RunController(opts ...Option) (*ControlResult, error) {
+	options := NewOptions(opts...)
+
+	c := &controlRun{
+		options:     options,
+		qps:         options.qps,
+		inputs:      newInputCache(options),
+		window:      map[string][]time.Duration{},
+		outstanding: map[int]bool{},
+	}
+	if c.qps <= 0 {
+		c.qps = options.sweepStartQPS
+	}
+	mt := mt19937.New()
+	mt.Seed(options.seed)
+	c.rng = rand.New(mt)
+	c.inputRng = inputRng(options.seed)
+
+	var err error
+	if options.virtualTime {
+		err = c.simulate()
+	} else {
+		err = c.replay()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ControlResult{
+		Samples: c.samples,
+		Result:  &ReplayResult{Queries: c.queries, Duration: c.duration},
+	}
+	if len(c.samples) == 0 {
+		return nil, errors.New("the run is shorter than a control interval")
+	}
+	last := c.samples[len(c.samples)*3/4:]
+	for _, sample := range last {
+		result.QPS += sample.QPS / float64(len(last))
+	}
+
+	trace := make(Trace, len(c.queries))
+	for ii, q := range c.queries {
+		trace[ii] = q.TraceEntry
+	}
+	trace.excludeWindows(result.Result, options)
+
+	return result, nil
+}
+
+type controlRun struct {
+	options *Options
+	rng     *rand.Rand
+	// draws the input indices apart from the arrivals, like in NewTrace
+	inputRng *rand.Rand
+	inputs   *inputCache
+
+	now   func() time.Duration
+	at    func(t time.Duration, f func())
+	run   func(q Query, onFinish func(Completion)) error
+	start time.Time
+
+	mu sync.Mutex
+	// counts pending timers and outstanding queries in real time
+	wg sync.WaitGroup
+	// the pending timers in real time, stopped when the run is cancelled
+	timers  map[*time.Timer]bool
+	qps     float64
+	queries []QueryResult
+	samples []ControlSample
+	// latencies by class since the last tick
+	window map[string][]time.Duration
+	// the queries without a result, and whether they were already counted as
+	// overdue
+	outstanding map[int]bool
+	duration    time.Duration
+}
+
+func (c *controlRun) replay() error {
+	c.start = time.Now()
+	c.timers = map[*time.Timer]bool{}
+	c.now = func() time.Duration {
+		return time.Since(c.start)
+	}
+	// called with mu held
+	c.at = func(t time.Duration, f func()) {
+		c.wg.Add(1)
+		var timer *time.Timer
+		timer = time.AfterFunc(time.Until(c.start.Add(t)), func() {
+			defer c.wg.Done()
+			c.mu.Lock()
+			delete(c.timers, timer)
+			c.mu.Unlock()
+			f()
+		})
+		c.timers[timer] = true
+	}
+	c.run = func(q Query, onFinish func(Completion)) error {
+		return runQuery(c.options.ctx, c.options, q, onFinish)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-c.options.ctx.Done():
+			c.mu.Lock()
+			for timer := range c.timers {
+				if timer.Stop() {
+					delete(c.timers, timer)
+					c.wg.Done()
+				}
+			}
+			c.mu.Unlock()
+		case <-stopped:
+		}
+	}()
+
+	c.mu.Lock()
+	c.schedule()
+	c.mu.Unlock()
+	c.wg.Wait()
+	close(stopped)
+	if err := c.options.ctx.Err(); err != nil {
+		return err
+	}
+
+	c.duration = c.now()
+	return nil
+}
+
+func (c *controlRun) simulate() error {
+	sim := &Simulator{}
+	c.now = sim.Now
+	c.at = sim.At
+	c.run = func(q Query, onFinish func(Completion)) error {
+		return simulateQuery(sim, c.options, q, onFinish)
+	}
+
+	c.mu.Lock()
+	c.schedule()
+	c.mu.Unlock()
+	sim.run(func() bool {
+		return c.options.ctx.Err() != nil
+	})
+	if err := c.options.ctx.Err(); err != nil {
+		return err
+	}
+
+	c.duration = c.now()
+	return nil
+}
+
+// Schedules the first arrival and the control ticks. Called with mu held.
+func (c *controlRun) schedule() {
+	c.at(c.options.arrivals(c.rng, c.qps, 0), c.arrive)
+	interval := c.options.controlInterval
+	for tick := interval; tick <= c.options.minDuration; tick += interval {
+		c.at(tick, c.tick)
+	}
+}
+
+func (c *controlRun) arrive() {
+	c.mu.Lock()
+	now := c.now()
+	if now >= c.options.minDuration || c.options.ctx.Err() != nil {
+		c.mu.Unlock()
+		return
+	}
+	tr := TraceEntry{
+		InputIndex: c.inputRng.Int(),
+		TimeStamp:  now,
+	}
+	c.options.schedule(&tr)
+	// the next arrival is scheduled first, so that runners completing
+	// synchronously do not hold it up
+	c.at(now+c.options.arrivals(c.rng, c.qps, now), c.arrive)
+	c.wg.Add(1)
+	c.mu.Unlock()
+
+	// the input is generated before the query is timed
+	input, err := c.inputs.get(tr)
+
+	c.mu.Lock()
+	tr.Index = len(c.queries)
+	c.queries = append(c.queries, QueryResult{TraceEntry: tr, Issued: c.now()})
+	c.outstanding[tr.Index] = false
+	c.mu.Unlock()
+
+	if err != nil {
+		c.finish(tr.Index, false, Completion{Err: err})
+		return
+	}
+	err = c.run(Query{TraceEntry: tr, Input: input, TraceStart: c.start}, func(completion Completion) {
+		c.finish(tr.Index, true, completion)
+	})
+	if err != nil {
+		c.finish(tr.Index, false, Completion{Err: err})
+	}
+}
+
+// Records the outcome of a query. Later outcomes of the same query are
+// ignored.
+func (c *controlRun) finish(ii int, finished bool, completion Completion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	overdue, ok := c.outstanding[ii]
+	if !ok {
+		return
+	}
+	delete(c.outstanding, ii)
+	defer c.wg.Done()
+
+	q := &c.queries[ii]
+	if finished {
+		q.Latency = c.now() - q.Issued
+		q.Finished = true
+		if !overdue {
+			c.window[q.Class] = append(c.window[q.Class], q.Latency)
+		}
+	}
+	q.Err = completion.Err
+	q.Timings = completion.Timings
+	q.Target = completion.Target
+}
+
+func (c *controlRun) tick() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+
+	for ii, counted := range c.outstanding {
+		q := c.queries[ii]
+		if bound, _ := c.options.latencyBoundFor(q.Class); !counted && now-q.Issued > bound {
+			c.window[q.Class] = append(c.window[q.Class], time.Duration(math.MaxInt64))
+			c.outstanding[ii] = true
+		}
+	}
+
+	// the controller acts on the class furthest from its SLO
+	sample := ControlSample{Time: now, QPS: c.qps}
+	var bound time.Duration
+	worst := -1.0
+	for class, latencies := range c.window {
+		sort.Slice(latencies, func(ii, jj int) bool {
+			return latencies[ii] < latencies[jj]
+		})
+		classBound, p := c.options.latencyBoundFor(class)
+		latency := percentile(latencies, p)
+		sample.Queries += len(latencies)
+		if ratio := float64(latency) / float64(classBound); ratio > worst {
+			worst = ratio
+			sample.Latency, sample.Class, bound = latency, class, classBound
+		}
+	}
+	c.window = map[string][]time.Duration{}
+
+	c.samples = append(c.samples, sample)
+	log.WithField("qps", sample.QPS).
+		WithField("latency", sample.Latency).
+		Debug("control interval")
+
+	if sample.Queries > 0 {
+		c.qps = math.Max(minControlQPS, c.options.controller.Next(c.qps, sample.Latency, bound))
+	}
+}
+
+// the controller never stops the load entirely
+const minControlQPS = 0.1
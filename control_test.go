@@ -0,0 +1,87 @@
+package synthetic_load
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunControllerConverges(t *testing.T) {
+	serviceRate := 100.0
+	expected := MMc{Servers: 4, ServiceRate: serviceRate}.MaxArrivalRate(100*time.Millisecond, 0.99)
+
+	for _, controller := range []RateController{PID(0.2, 0.02, 0), AIMD(5, 0.9)} {
+		result, err := RunController(
+			VirtualTime(),
+			Controller(controller),
+			InputContextRunner(NewSimulatedServerRunner(4, Exponential(1/serviceRate), SimulatedSeed(1))),
+			InputGenerator(func(idx int) ([]byte, error) {
+				return nil, nil
+			}),
+			LatencyBound(100*time.Millisecond),
+			LatencyBoundPercentile(0.99),
+			QPS(50),
+			ControlInterval(5*time.Second),
+			MinDuration(30*time.Minute),
+		)
+		assert.NoError(t, err)
+		assert.Len(t, result.Samples, 360)
+		assert.InEpsilon(t, expected, result.QPS, 0.2, "%T", controller)
+		assert.Equal(t, 50.0, result.Samples[0].QPS)
+	}
+}
+
+func TestRunControllerRealTime(t *testing.T) {
+	result, err := RunController(
+		InputRunner(SleepingRunner{}),
+		InputGenerator(func(idx int) ([]byte, error) {
+			return nil, nil
+		}),
+		Controller(AIMD(10, 0.5)),
+		QPS(100),
+		ControlInterval(100*time.Millisecond),
+		MinDuration(time.Second),
+	)
+	assert.NoError(t, err)
+	assert.Len(t, result.Samples, 10)
+	assert.True(t, result.Samples[9].QPS > 100)
+	for _, q := range result.Result.Queries {
+		assert.True(t, q.Finished)
+	}
+}
+
+func TestRunControllerCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := RunController(
+		Context(ctx),
+		InputRunner(SleepingRunner{}),
+		InputGenerator(func(idx int) ([]byte, error) {
+			return nil, nil
+		}),
+		QPS(100),
+		ControlInterval(100*time.Millisecond),
+		MinDuration(time.Minute),
+	)
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestRunControllerRunnerFinishingAndFailing(t *testing.T) {
+	result, err := RunController(
+		InputRunner(finishingFailingRunner{}),
+		InputGenerator(func(idx int) ([]byte, error) {
+			return nil, nil
+		}),
+		QPS(100),
+		ControlInterval(100*time.Millisecond),
+		MinDuration(300*time.Millisecond),
+	)
+	assert.NoError(t, err)
+	assert.Len(t, result.Samples, 3)
+	assert.True(t, result.Result.Duration >= 300*time.Millisecond)
+}
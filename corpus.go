@@ -0,0 +1,168 @@
+package synthetic_load
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// One request of a JSON Lines corpus.
+type CorpusRequest struct {
+	// The input bytes of the query.
+	Payload []byte
+	// The path the request targets, for HTTP-style runners.
+	Path string
+	// Headers of the request, for HTTP-style runners.
+	Header http.Header
+}
+
+// An input generator serving the requests of a JSON Lines corpus, one request
+// per line, picked by InputIndex modulo the number of requests.
+type Corpus struct {
+	payloadField string
+	pathField    string
+	headerField  string
+	requests     []CorpusRequest
+}
+
+type CorpusOption func(*Corpus)
+
+// Uses a field of every line as the payload rather than the whole line. A
+// string field gives its contents, any other value its JSON encoding.
+func CorpusPayloadField(name string) CorpusOption {
+	return func(c *Corpus) {
+		c.payloadField = name
+	}
+}
+
+// The field holding the target path of a request, "path" by default.
+func CorpusPathField(name string) CorpusOption {
+	return func(c *Corpus) {
+		c.pathField = name
+	}
+}
+
+// The field holding the headers of a request as an object of strings or
+// string arrays, "headers" by default.
+func CorpusHeaderField(name string) CorpusOption {
+	return func(c *Corpus) {
+		c.headerField = name
+	}
+}
+
+// Reads a corpus from a JSON Lines file.
+func LoadCorpus(path string, opts ...CorpusOption) (*Corpus, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewCorpus(f, opts...)
+}
+
+// Reads a corpus in the JSON Lines format. Blank lines are skipped.
+func NewCorpus(r io.Reader, opts ...CorpusOption) (*Corpus, error) {
+	c := &Corpus{
+		pathField:   "path",
+		headerField: "headers",
+	}
+	for _, o := range opts {
+		o(c)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		request, err := c.parse(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		c.requests = append(c.requests, request)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(c.requests) == 0 {
+		return nil, errors.New("empty corpus")
+	}
+
+	return c, nil
+}
+
+func (c *Corpus) parse(line []byte) (CorpusRequest, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return CorpusRequest{}, err
+	}
+
+	request := CorpusRequest{
+		Payload: append([]byte(nil), line...),
+	}
+	if c.payloadField != "" {
+		raw, ok := fields[c.payloadField]
+		if !ok {
+			return CorpusRequest{}, fmt.Errorf("no %q field", c.payloadField)
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			request.Payload = []byte(s)
+		} else {
+			request.Payload = append([]byte(nil), raw...)
+		}
+	}
+
+	if raw, ok := fields[c.pathField]; ok {
+		if err := json.Unmarshal(raw, &request.Path); err != nil {
+			return CorpusRequest{}, fmt.Errorf("%q field: %v", c.pathField, err)
+		}
+	}
+
+	if raw, ok := fields[c.headerField]; ok {
+		headers := map[string]json.RawMessage{}
+		if err := json.Unmarshal(raw, &headers); err != nil {
+			return CorpusRequest{}, fmt.Errorf("%q field: %v", c.headerField, err)
+		}
+		request.Header = http.Header{}
+		for key, value := range headers {
+			var values []string
+			var single string
+			if err := json.Unmarshal(value, &single); err == nil {
+				values = []string{single}
+			} else if err := json.Unmarshal(value, &values); err != nil {
+				return CorpusRequest{}, fmt.Errorf("header %q: %v", key, err)
+			}
+			for _, v := range values {
+				request.Header.Add(key, v)
+			}
+		}
+	}
+
+	return request, nil
+}
+
+// The request the input index maps to.
+func (c *Corpus) Request(idx int) *CorpusRequest {
+	ii := idx % len(c.requests)
+	if ii < 0 {
+		ii += len(c.requests)
+	}
+	return &c.requests[ii]
+}
+
+// The payload of the request the input index maps to. Pass it to
+// InputGenerator.
+func (c *Corpus) Generate(idx int) ([]byte, error) {
+	return c.Request(idx).Payload, nil
+}
+
+// The number of requests in the corpus.
+func (c *Corpus) Len() int {
+	return len(c.requests)
+}
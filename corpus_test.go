@@ -0,0 +1,69 @@
+package synthetic_load
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testCorpus = `{"path": "/v1/a", "headers": {"X-Model": "resnet"}, "body": "first"}
+
+{"path": "/v1/b?batch=2", "headers": {"X-Tag": ["x", "y"], "Content-Type": "application/json"}, "body": {"n": 2}}
+`
+
+func TestCorpus(t *testing.T) {
+	corpus, err := NewCorpus(strings.NewReader(testCorpus))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, corpus.Len())
+	input, err := corpus.Generate(3)
+	assert.NoError(t, err)
+	assert.Contains(t, string(input), `"/v1/b?batch=2"`)
+
+	corpus, err = NewCorpus(strings.NewReader(testCorpus), CorpusPayloadField("body"))
+	assert.NoError(t, err)
+	input, _ = corpus.Generate(0)
+	assert.Equal(t, "first", string(input))
+	input, _ = corpus.Generate(-1)
+	assert.Equal(t, `{"n": 2}`, string(input))
+	assert.Equal(t, []string{"x", "y"}, corpus.Request(1).Header["X-Tag"])
+
+	_, err = NewCorpus(strings.NewReader(testCorpus), CorpusPayloadField("missing"))
+	assert.Error(t, err)
+	_, err = NewCorpus(strings.NewReader("not json\n"))
+	assert.Error(t, err)
+}
+
+func TestHTTPCorpus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Write([]byte(req.URL.RequestURI() + " " + req.Header.Get("X-Model") + " " + req.Header.Get("Content-Type") + " " + string(body)))
+	}))
+	defer server.Close()
+
+	corpus, err := NewCorpus(strings.NewReader(testCorpus), CorpusPayloadField("body"))
+	assert.NoError(t, err)
+	runner, err := NewHTTPRunner(server.URL+"/", HTTPCorpus(corpus))
+	assert.NoError(t, err)
+
+	var responses []string
+	for _, idx := range []int{0, 1} {
+		input, _ := corpus.Generate(idx)
+		err := runner.RunContext(context.Background(), Query{
+			TraceEntry: TraceEntry{InputIndex: idx},
+			Input:      input,
+		}, func(c Completion) {
+			assert.NoError(t, c.Err)
+			responses = append(responses, string(c.Response))
+		})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{
+		"/v1/a resnet application/octet-stream first",
+		`/v1/b?batch=2  application/json {"n": 2}`,
+	}, responses)
+}
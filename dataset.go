@@ -0,0 +1,124 @@
+package synthetic_load
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// An input generator serving the files of a dataset, such as a subset of the
+// ImageNet validation set. Files are ordered by path so that an InputIndex,
+// and hence a trace seed, always maps to the same file.
+type DatasetInputGenerator struct {
+	files      []string
+	extensions []string
+	preload    bool
+	data       [][]byte
+}
+
+type DatasetOption func(*DatasetInputGenerator)
+
+// Reads every file into memory up front, so that queries do not touch the
+// disk.
+func DatasetPreload() DatasetOption {
+	return func(d *DatasetInputGenerator) {
+		d.preload = true
+	}
+}
+
+// Only keeps the files with one of the given extensions, e.g. ".jpg".
+func DatasetExtensions(extensions ...string) DatasetOption {
+	return func(d *DatasetInputGenerator) {
+		d.extensions = extensions
+	}
+}
+
+// Indexes the files below a directory, or the files matching a glob pattern.
+func NewDatasetInputGenerator(pattern string, opts ...DatasetOption) (*DatasetInputGenerator, error) {
+	d := &DatasetInputGenerator{}
+	for _, o := range opts {
+		o(d)
+	}
+
+	paths := []string{}
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		err := filepath.Walk(pattern, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			paths = append(paths, path)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		paths = matches
+	}
+
+	for _, path := range paths {
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() || !d.matches(path) {
+			continue
+		}
+		d.files = append(d.files, path)
+	}
+	if len(d.files) == 0 {
+		return nil, errors.New("no dataset files match " + pattern)
+	}
+	sort.Strings(d.files)
+
+	if d.preload {
+		d.data = make([][]byte, len(d.files))
+		for ii, path := range d.files {
+			buf, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			d.data[ii] = buf
+		}
+	}
+
+	return d, nil
+}
+
+// The contents of the file the input index maps to, modulo the dataset size.
+// Pass it to InputGenerator.
+func (d *DatasetInputGenerator) Generate(idx int) ([]byte, error) {
+	ii := idx % len(d.files)
+	if ii < 0 {
+		ii += len(d.files)
+	}
+	if d.data != nil {
+		return d.data[ii], nil
+	}
+	return ioutil.ReadFile(d.files[ii])
+}
+
+// The number of files in the dataset.
+func (d *DatasetInputGenerator) Len() int {
+	return len(d.files)
+}
+
+// The paths of the dataset files, in input index order.
+func (d *DatasetInputGenerator) Files() []string {
+	return append([]string(nil), d.files...)
+}
+
+func (d *DatasetInputGenerator) matches(path string) bool {
+	if len(d.extensions) == 0 {
+		return true
+	}
+	for _, extension := range d.extensions {
+		if strings.EqualFold(filepath.Ext(path), extension) {
+			return true
+		}
+	}
+	return false
+}
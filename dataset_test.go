@@ -0,0 +1,54 @@
+package synthetic_load
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/seehuhn/mt19937"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatasetInputGenerator(t *testing.T) {
+	cat, err := ioutil.ReadFile("_fixtures/cat.jpg")
+	assert.NoError(t, err)
+
+	for _, pattern := range []string{"_fixtures", "_fixtures/*.jpg"} {
+		for _, preload := range []bool{false, true} {
+			opts := []DatasetOption{DatasetExtensions(".jpg")}
+			if preload {
+				opts = append(opts, DatasetPreload())
+			}
+			dataset, err := NewDatasetInputGenerator(pattern, opts...)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"_fixtures/cat.jpg", "_fixtures/chicken.jpg"}, dataset.Files())
+
+			input, err := dataset.Generate(4)
+			assert.NoError(t, err)
+			assert.Equal(t, cat, input)
+			input, err = dataset.Generate(-2)
+			assert.NoError(t, err)
+			assert.Equal(t, cat, input)
+		}
+	}
+
+	_, err = NewDatasetInputGenerator("_fixtures/*.png")
+	assert.Error(t, err)
+}
+
+func TestTraceInputsFollowSeed(t *testing.T) {
+	first := NewTrace(Seed(5), MinDuration(time.Second), QPS(100))
+	second := NewTrace(Seed(5), MinDuration(time.Second), QPS(100))
+	assert.Equal(t, first, second)
+
+	// the time stamps only depend on the arrivals drawn from the seed
+	mt := mt19937.New()
+	mt.Seed(5)
+	rng := rand.New(mt)
+	timeStamp := time.Duration(0)
+	for _, tr := range first {
+		timeStamp += time.Duration((rng.ExpFloat64() / 100) * float64(time.Second))
+		assert.Equal(t, timeStamp, tr.TimeStamp)
+	}
+}
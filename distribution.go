@@ -0,0 +1,148 @@
+package synthetic_load
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// A distribution of non-negative values, such as service times in seconds.
+type Distribution interface {
+	Sample(rng *rand.Rand) float64
+	Mean() float64
+}
+
+type constantDistribution float64
+
+// Always the same value.
+func Constant(v float64) Distribution {
+	return constantDistribution(v)
+}
+
+func (d constantDistribution) Sample(rng *rand.Rand) float64 {
+	return float64(d)
+}
+
+func (d constantDistribution) Mean() float64 {
+	return float64(d)
+}
+
+type exponentialDistribution float64
+
+// Exponentially distributed values with the given mean.
+func Exponential(mean float64) Distribution {
+	return exponentialDistribution(mean)
+}
+
+func (d exponentialDistribution) Sample(rng *rand.Rand) float64 {
+	return rng.ExpFloat64() * float64(d)
+}
+
+func (d exponentialDistribution) Mean() float64 {
+	return float64(d)
+}
+
+type logNormalDistribution struct {
+	mu, sigma float64
+}
+
+// Values whose logarithm is normally distributed with mean mu and standard
+// deviation sigma.
+func LogNormal(mu, sigma float64) Distribution {
+	return logNormalDistribution{mu: mu, sigma: sigma}
+}
+
+func (d logNormalDistribution) Sample(rng *rand.Rand) float64 {
+	return math.Exp(d.mu + d.sigma*rng.NormFloat64())
+}
+
+func (d logNormalDistribution) Mean() float64 {
+	return math.Exp(d.mu + d.sigma*d.sigma/2)
+}
+
+type empiricalDistribution []float64
+
+// Values drawn uniformly from recorded samples, of which there must be at
+// least one.
+func Empirical(samples []float64) Distribution {
+	return empiricalDistribution(append([]float64(nil), samples...))
+}
+
+// Durations drawn uniformly from recorded latencies, in seconds.
+func EmpiricalDurations(samples []time.Duration) Distribution {
+	seconds := make([]float64, len(samples))
+	for ii, d := range samples {
+		seconds[ii] = d.Seconds()
+	}
+	return empiricalDistribution(seconds)
+}
+
+func (d empiricalDistribution) Sample(rng *rand.Rand) float64 {
+	return d[rng.Intn(len(d))]
+}
+
+func (d empiricalDistribution) Mean() float64 {
+	sum := 0.0
+	for _, v := range d {
+		sum += v
+	}
+	return sum / float64(len(d))
+}
+
+type uniformDistribution struct {
+	min, max float64
+}
+
+// Values distributed uniformly between min and max.
+func Uniform(min, max float64) Distribution {
+	return uniformDistribution{min: min, max: max}
+}
+
+func (d uniformDistribution) Sample(rng *rand.Rand) float64 {
+	return d.min + rng.Float64()*(d.max-d.min)
+}
+
+func (d uniformDistribution) Mean() float64 {
+	return (d.min + d.max) / 2
+}
+
+type histogramDistribution struct {
+	bounds     []float64
+	cumulative []float64
+}
+
+// Values drawn from a histogram. Bucket ii spans bounds[ii] to bounds[ii+1]
+// and is chosen in proportion to weights[ii]; values are uniform within a
+// bucket. There must be one more bound than weights.
+func Histogram(bounds, weights []float64) Distribution {
+	d := histogramDistribution{bounds: append([]float64(nil), bounds...)}
+	total := 0.0
+	for _, w := range weights {
+		total += w
+		d.cumulative = append(d.cumulative, total)
+	}
+	return d
+}
+
+func (d histogramDistribution) Sample(rng *rand.Rand) float64 {
+	total := d.cumulative[len(d.cumulative)-1]
+	ii := sort.SearchFloat64s(d.cumulative, rng.Float64()*total)
+	if ii >= len(d.cumulative) {
+		ii = len(d.cumulative) - 1
+	}
+	return d.bounds[ii] + rng.Float64()*(d.bounds[ii+1]-d.bounds[ii])
+}
+
+func (d histogramDistribution) Mean() float64 {
+	sum, previous := 0.0, 0.0
+	for ii, c := range d.cumulative {
+		sum += (c - previous) * (d.bounds[ii] + d.bounds[ii+1]) / 2
+		previous = c
+	}
+	return sum / previous
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,234 @@
+package synthetic_load
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// A ContextRunner that hands every query to a command. By default a new
+// process is spawned per query with the input on its stdin and its stdout as
+// the response. With ExecWorkers, a pool of long-lived processes exchanges
+// framed requests and responses over stdin and stdout instead.
+type ExecRunner struct {
+	name    string
+	args    []string
+	dir     string
+	env     []string
+	workers int
+	framing Framing
+	pool    chan *execWorker
+}
+
+type ExecOption func(*ExecRunner)
+
+// Keeps n long-lived processes that read framed requests from stdin and write
+// one framed response per request to stdout.
+func ExecWorkers(n int, framing Framing) ExecOption {
+	return func(r *ExecRunner) {
+		r.workers = n
+		r.framing = framing
+	}
+}
+
+// The working directory of the command.
+func ExecDir(dir string) ExecOption {
+	return func(r *ExecRunner) {
+		r.dir = dir
+	}
+}
+
+// The environment of the command, in the form "key=value".
+func ExecEnv(env ...string) ExecOption {
+	return func(r *ExecRunner) {
+		r.env = env
+	}
+}
+
+func NewExecRunner(name string, args []string, opts ...ExecOption) (*ExecRunner, error) {
+	r := &ExecRunner{
+		name: name,
+		args: args,
+	}
+	for _, o := range opts {
+		o(r)
+	}
+
+	if r.workers > 0 {
+		r.pool = make(chan *execWorker, r.workers)
+		for ii := 0; ii < r.workers; ii++ {
+			w, err := r.startWorker()
+			if err != nil {
+				r.Close()
+				return nil, err
+			}
+			r.pool <- w
+		}
+	}
+
+	return r, nil
+}
+
+func (r *ExecRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	if r.pool == nil {
+		return r.spawn(ctx, q, onFinish)
+	}
+
+	var w *execWorker
+	select {
+	case w = <-r.pool:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if w.cmd == nil {
+		// a worker that failed to restart is retried by the next query
+		restarted, err := r.startWorker()
+		if err != nil {
+			r.pool <- w
+			return err
+		}
+		w = restarted
+	}
+
+	start := time.Now()
+	response, err := w.roundTrip(ctx, r.framing, q.Input)
+	if err != nil {
+		// the process is in an unknown state, replace it
+		w.kill()
+		r.pool <- r.restartWorker()
+	} else {
+		r.pool <- w
+	}
+	onFinish(Completion{
+		Response: response,
+		Err:      err,
+		Timings:  map[string]time.Duration{"total": time.Since(start)},
+	})
+
+	return nil
+}
+
+func (r *ExecRunner) spawn(ctx context.Context, q Query, onFinish func(Completion)) error {
+	cmd := r.command(ctx)
+	cmd.Stdin = bytes.NewReader(q.Input)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	err := cmd.Wait()
+	onFinish(Completion{
+		Response: stdout.Bytes(),
+		Err:      err,
+		Timings:  map[string]time.Duration{"total": time.Since(start)},
+	})
+
+	return nil
+}
+
+// Stops the worker processes.
+func (r *ExecRunner) Close() error {
+	if r.pool == nil {
+		return nil
+	}
+	for {
+		select {
+		case w := <-r.pool:
+			w.kill()
+		default:
+			return nil
+		}
+	}
+}
+
+func (r *ExecRunner) command(ctx context.Context) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, r.name, r.args...)
+	cmd.Dir = r.dir
+	cmd.Env = r.env
+	return cmd
+}
+
+func (r *ExecRunner) startWorker() (*execWorker, error) {
+	cmd := r.command(context.Background())
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &execWorker{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}, nil
+}
+
+// Starts a replacement worker, or returns a placeholder without a process
+// that keeps the worker's place in the pool if the command fails to start.
+func (r *ExecRunner) restartWorker() *execWorker {
+	w, err := r.startWorker()
+	if err != nil {
+		log.WithError(err).Error("unable to restart exec worker")
+		return &execWorker{}
+	}
+	return w
+}
+
+// A long-lived process serving one request at a time.
+type execWorker struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	killed sync.Once
+}
+
+func (w *execWorker) roundTrip(ctx context.Context, framing Framing, input []byte) ([]byte, error) {
+	type frame struct {
+		data []byte
+		err  error
+	}
+	done := make(chan frame, 1)
+	go func() {
+		if err := framing.WriteFrame(w.stdin, input); err != nil {
+			done <- frame{err: err}
+			return
+		}
+		data, err := framing.ReadFrame(w.stdout)
+		done <- frame{data: data, err: err}
+	}()
+
+	select {
+	case f := <-done:
+		return f.data, f.err
+	case <-ctx.Done():
+		// killing the process unblocks the pending read
+		w.kill()
+		<-done
+		return nil, ctx.Err()
+	}
+}
+
+// Stops the process, once.
+func (w *execWorker) kill() {
+	if w.cmd == nil {
+		return
+	}
+	w.killed.Do(func() {
+		w.stdin.Close()
+		if w.cmd.Process != nil {
+			w.cmd.Process.Kill()
+		}
+		w.cmd.Wait()
+	})
+}
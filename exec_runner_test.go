@@ -0,0 +1,70 @@
+package synthetic_load
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecRunner(t *testing.T) {
+	for name, opts := range map[string][]ExecOption{
+		"spawn":         nil,
+		"line":          {ExecWorkers(2, LineFraming{})},
+		"length-prefix": {ExecWorkers(2, LengthPrefixFraming{})},
+	} {
+		runner, err := NewExecRunner("cat", nil, opts...)
+		assert.NoError(t, err, name)
+
+		result, err := burstTrace(8).Measure(
+			InputContextRunner(runner),
+			InputGenerator(func(idx int) ([]byte, error) {
+				return []byte(strconv.Itoa(idx)), nil
+			}),
+		)
+		assert.NoError(t, err, name)
+		assert.Equal(t, 8, result.Completed(), name)
+
+		err = runner.RunContext(context.Background(), Query{Input: []byte("42")}, func(c Completion) {
+			assert.NoError(t, c.Err, name)
+			assert.Equal(t, "42", string(c.Response), name)
+		})
+		assert.NoError(t, err, name)
+		assert.NoError(t, runner.Close(), name)
+	}
+}
+
+func TestExecRunnerRestartFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec_runner")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// a worker that exits without answering, and cannot be restarted once
+	// the script is gone
+	script := filepath.Join(dir, "worker.sh")
+	assert.NoError(t, ioutil.WriteFile(script, []byte("#!/bin/sh\nexit 0\n"), 0755))
+
+	runner, err := NewExecRunner(script, nil, ExecWorkers(1, LineFraming{}))
+	assert.NoError(t, err)
+	defer runner.Close()
+	assert.NoError(t, os.Remove(script))
+
+	err = runner.RunContext(context.Background(), Query{Input: []byte("1")}, func(c Completion) {
+		assert.Error(t, c.Err)
+	})
+	assert.NoError(t, err)
+
+	// the failed restart keeps its place in the pool rather than blocking the
+	// next query
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = runner.RunContext(ctx, Query{Input: []byte("2")}, func(c Completion) {
+		t.Error("unexpected completion")
+	})
+	assert.Error(t, err)
+	assert.NotEqual(t, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,91 @@
+package synthetic_load
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Delimits requests and responses on a byte stream.
+type Framing interface {
+	WriteFrame(w io.Writer, frame []byte) error
+	ReadFrame(r *bufio.Reader) ([]byte, error)
+}
+
+// Newline-delimited frames. Frames must not contain a newline.
+type LineFraming struct{}
+
+func (LineFraming) WriteFrame(w io.Writer, frame []byte) error {
+	if bytes.IndexByte(frame, '\n') >= 0 {
+		return errors.New("line frame contains a newline")
+	}
+	_, err := w.Write(append(frame[:len(frame):len(frame)], '\n'))
+	return err
+}
+
+func (LineFraming) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	return line[:len(line)-1], nil
+}
+
+// Frames preceded by their length as a 32-bit big-endian integer. Frames
+// longer than MaxSize, 64 MiB if 0, are rejected before they are read.
+type LengthPrefixFraming struct {
+	MaxSize int
+}
+
+func (LengthPrefixFraming) WriteFrame(w io.Writer, frame []byte) error {
+	buf := make([]byte, 4+len(frame))
+	binary.BigEndian.PutUint32(buf, uint32(len(frame)))
+	copy(buf[4:], frame)
+	_, err := w.Write(buf)
+	return err
+}
+
+func (f LengthPrefixFraming) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	maxSize := f.MaxSize
+	if maxSize <= 0 {
+		maxSize = 64 << 20
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if uint64(size) > uint64(maxSize) {
+		return nil, fmt.Errorf("frame of %d bytes, at most %d allowed", size, maxSize)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// Frames of a fixed size, for protocols with fixed-size requests and
+// responses.
+type FixedSizeFraming struct {
+	Size int
+}
+
+func (f FixedSizeFraming) WriteFrame(w io.Writer, frame []byte) error {
+	if len(frame) != f.Size {
+		return fmt.Errorf("frame of %d bytes, expected %d", len(frame), f.Size)
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+func (f FixedSizeFraming) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	frame := make([]byte, f.Size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
@@ -0,0 +1,244 @@
+package synthetic_load
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Invokes a unary method through generated stubs and returns the serialized
+// response.
+type GRPCInvoker func(ctx context.Context, conn *grpc.ClientConn, q Query) ([]byte, error)
+
+// A ContextRunner that calls a unary gRPC method for every query.
+type GRPCRunner struct {
+	method      string
+	invoker     GRPCInvoker
+	request     protoreflect.MessageDescriptor
+	field       protoreflect.FieldDescriptor
+	channels    int
+	timeout     time.Duration
+	metadata    metadata.MD
+	priorityKey string
+	deadlineKey string
+	dialOptions []grpc.DialOption
+	conns       []*grpc.ClientConn
+	next        uint64
+}
+
+type GRPCOption func(*GRPCRunner)
+
+// Invokes the method with the given full name ("/package.Service/Method")
+// without generated stubs. The input bytes are sent as the serialized request
+// message, unless GRPCRequestField wraps them.
+func GRPCMethod(fullMethod string) GRPCOption {
+	return func(r *GRPCRunner) {
+		r.method = fullMethod
+	}
+}
+
+// Invokes the method through generated stubs rather than by name.
+func GRPCInvoke(invoker GRPCInvoker) GRPCOption {
+	return func(r *GRPCRunner) {
+		r.invoker = invoker
+	}
+}
+
+// Wraps the input bytes in the named bytes field of a request message built
+// from its descriptor.
+func GRPCRequestField(request protoreflect.MessageDescriptor, field protoreflect.Name) GRPCOption {
+	return func(r *GRPCRunner) {
+		r.request = request
+		r.field = request.Fields().ByName(field)
+	}
+}
+
+// The number of connections the calls are spread over round-robin.
+func GRPCChannels(n int) GRPCOption {
+	return func(r *GRPCRunner) {
+		r.channels = n
+	}
+}
+
+// The deadline of every call, on top of the replay's query timeout.
+func GRPCTimeout(d time.Duration) GRPCOption {
+	return func(r *GRPCRunner) {
+		r.timeout = d
+	}
+}
+
+// Adds outgoing metadata to every call.
+func GRPCMetadata(key, value string) GRPCOption {
+	return func(r *GRPCRunner) {
+		r.metadata.Append(key, value)
+	}
+}
+
+// The metadata keys the priority and the absolute deadline of a query are
+// forwarded in, "x-priority" and "x-deadline" by default. The deadline is in
+// RFC 3339 format. An empty key leaves the value out.
+func GRPCPriorityMetadata(priorityKey, deadlineKey string) GRPCOption {
+	return func(r *GRPCRunner) {
+		r.priorityKey = priorityKey
+		r.deadlineKey = deadlineKey
+	}
+}
+
+// Options used to dial the endpoint, insecure credentials by default.
+func GRPCDialOptions(dialOptions ...grpc.DialOption) GRPCOption {
+	return func(r *GRPCRunner) {
+		r.dialOptions = dialOptions
+	}
+}
+
+// Creates a GRPCRunner connected to the target. Either GRPCMethod or
+// GRPCInvoke must be given.
+func NewGRPCRunner(target string, opts ...GRPCOption) (*GRPCRunner, error) {
+	r := &GRPCRunner{
+		channels:    1,
+		metadata:    metadata.MD{},
+		priorityKey: "x-priority",
+		deadlineKey: "x-deadline",
+		dialOptions: []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		},
+	}
+	for _, o := range opts {
+		o(r)
+	}
+
+	if r.channels < 1 {
+		return nil, errors.New("grpc runner needs at least one channel")
+	}
+	if r.method == "" && r.invoker == nil {
+		return nil, errors.New("grpc runner needs a method or an invoker")
+	}
+	if r.request != nil && (r.field == nil || r.field.Kind() != protoreflect.BytesKind) {
+		return nil, fmt.Errorf("request message %v has no such bytes field", r.request.FullName())
+	}
+
+	for ii := 0; ii < r.channels; ii++ {
+		conn, err := grpc.NewClient(target, r.dialOptions...)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.conns = append(r.conns, conn)
+	}
+
+	return r, nil
+}
+
+func (r *GRPCRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	payload := q.Input
+	if r.request != nil {
+		msg := dynamicpb.NewMessage(r.request)
+		msg.Set(r.field, protoreflect.ValueOfBytes(q.Input))
+		var err error
+		if payload, err = proto.Marshal(msg); err != nil {
+			return err
+		}
+	}
+
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	md := r.metadata
+	if q.Priority != 0 && r.priorityKey != "" {
+		md = md.Copy()
+		md.Set(r.priorityKey, strconv.Itoa(q.Priority))
+	}
+	if deadline, ok := q.DeadlineTime(); ok && r.deadlineKey != "" {
+		md = md.Copy()
+		md.Set(r.deadlineKey, deadline.UTC().Format(time.RFC3339Nano))
+	}
+	if len(md) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, md)
+	}
+
+	conn := r.conns[atomic.AddUint64(&r.next, 1)%uint64(len(r.conns))]
+
+	start := time.Now()
+	var response []byte
+	var err error
+	if r.invoker != nil {
+		q.Input = payload
+		response, err = r.invoker(ctx, conn, q)
+	} else {
+		resp := &rawMessage{}
+		err = conn.Invoke(ctx, r.method, &rawMessage{data: payload}, resp, grpc.ForceCodec(rawCodec{}))
+		response = resp.data
+	}
+	onFinish(Completion{
+		Response: response,
+		Err:      err,
+		Timings:  map[string]time.Duration{"total": time.Since(start)},
+	})
+
+	return nil
+}
+
+func (r *GRPCRunner) Close() error {
+	var err error
+	for _, conn := range r.conns {
+		if e := conn.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// The number of queries that ended with each gRPC status code.
+func GRPCCodes(result *ReplayResult) map[codes.Code]int {
+	counts := map[codes.Code]int{}
+	for _, q := range result.Queries {
+		if q.Finished && !q.Excluded {
+			counts[status.Code(q.Err)]++
+		}
+	}
+	return counts
+}
+
+// A message that is already serialized.
+type rawMessage struct {
+	data []byte
+}
+
+// Passes serialized messages through unchanged. It keeps the "proto" name so
+// that servers decode the payload as usual.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(*rawMessage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return msg.data, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(*rawMessage)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	msg.data = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
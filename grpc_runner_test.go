@@ -0,0 +1,178 @@
+package synthetic_load
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Starts an in-process server that echoes the request of any method, except
+// for /test.Echo/Busy which fails.
+func startEchoGRPCServer(t *testing.T) (string, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server := grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+			method, _ := grpc.MethodFromServerStream(stream)
+			md, _ := metadata.FromIncomingContext(stream.Context())
+			if method == "/test.Echo/Busy" || len(md.Get("x-client")) == 0 {
+				return status.Error(codes.ResourceExhausted, "busy")
+			}
+			msg := &rawMessage{}
+			if err := stream.RecvMsg(msg); err != nil {
+				return err
+			}
+			return stream.SendMsg(msg)
+		}),
+	)
+	go server.Serve(lis)
+
+	return lis.Addr().String(), server.Stop
+}
+
+func TestGRPCRunner(t *testing.T) {
+	addr, stop := startEchoGRPCServer(t)
+	defer stop()
+
+	runner, err := NewGRPCRunner(
+		addr,
+		GRPCMethod("/test.Echo/Predict"),
+		GRPCMetadata("x-client", "synthetic"),
+		GRPCChannels(2),
+	)
+	assert.NoError(t, err)
+	defer runner.Close()
+
+	result, err := burstTrace(4).Measure(
+		InputContextRunner(runner),
+		InputGenerator(func(idx int) ([]byte, error) {
+			return []byte("input"), nil
+		}),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Errors())
+	assert.Equal(t, map[codes.Code]int{codes.OK: 4}, GRPCCodes(result))
+
+	busy, err := NewGRPCRunner(addr, GRPCMethod("/test.Echo/Busy"))
+	assert.NoError(t, err)
+	defer busy.Close()
+
+	result, err = burstTrace(4).Measure(InputContextRunner(busy))
+	assert.NoError(t, err)
+	assert.Equal(t, map[codes.Code]int{codes.ResourceExhausted: 4}, GRPCCodes(result))
+
+	_, err = NewGRPCRunner(addr, GRPCMethod("/test.Echo/Predict"), GRPCChannels(0))
+	assert.Error(t, err)
+}
+
+func TestGRPCRequestField(t *testing.T) {
+	addr, stop := startEchoGRPCServer(t)
+	defer stop()
+
+	runner, err := NewGRPCRunner(
+		addr,
+		GRPCMethod("/test.Echo/Predict"),
+		GRPCRequestField((&wrapperspb.BytesValue{}).ProtoReflect().Descriptor(), "value"),
+		GRPCMetadata("x-client", "synthetic"),
+	)
+	assert.NoError(t, err)
+	defer runner.Close()
+
+	var response []byte
+	err = runner.RunContext(context.Background(), Query{Input: []byte("input")}, func(c Completion) {
+		assert.NoError(t, c.Err)
+		response = c.Response
+	})
+	assert.NoError(t, err)
+
+	msg := &wrapperspb.BytesValue{}
+	assert.NoError(t, proto.Unmarshal(response, msg))
+	assert.Equal(t, []byte("input"), msg.Value)
+}
+
+func TestGRPCInvoke(t *testing.T) {
+	addr, stop := startEchoGRPCServer(t)
+	defer stop()
+
+	// invokes the method like a generated client stub would
+	runner, err := NewGRPCRunner(
+		addr,
+		GRPCInvoke(func(ctx context.Context, conn *grpc.ClientConn, q Query) ([]byte, error) {
+			out := &wrapperspb.BytesValue{}
+			if err := conn.Invoke(ctx, "/test.Echo/Predict", wrapperspb.Bytes(q.Input), out); err != nil {
+				return nil, err
+			}
+			return out.Value, nil
+		}),
+		GRPCMetadata("x-client", "synthetic"),
+	)
+	assert.NoError(t, err)
+	defer runner.Close()
+
+	var response []byte
+	err = runner.RunContext(context.Background(), Query{Input: []byte("input")}, func(c Completion) {
+		assert.NoError(t, c.Err)
+		response = c.Response
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("input"), response)
+
+	_, err = NewGRPCRunner(addr)
+	assert.Error(t, err)
+}
+
+func TestGRPCRunnerForwardsPriority(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	incoming := make(chan metadata.MD, 2)
+	server := grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+			md, _ := metadata.FromIncomingContext(stream.Context())
+			incoming <- md
+			msg := &rawMessage{}
+			if err := stream.RecvMsg(msg); err != nil {
+				return err
+			}
+			return stream.SendMsg(msg)
+		}),
+	)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	runner, err := NewGRPCRunner(lis.Addr().String(), GRPCMethod("/test.Echo/Predict"))
+	assert.NoError(t, err)
+	defer runner.Close()
+
+	trace := Trace{
+		{Index: 0, InputIndex: 1, Priority: 2, Deadline: time.Second},
+		{Index: 1, InputIndex: 2},
+	}
+	before := time.Now()
+	result, err := trace.Measure(InputContextRunner(runner))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Errors())
+
+	first, second := <-incoming, <-incoming
+	if len(first.Get("x-priority")) == 0 {
+		first, second = second, first
+	}
+	assert.Equal(t, []string{"2"}, first.Get("x-priority"))
+	assert.Len(t, first.Get("x-deadline"), 1)
+	deadline, err := time.Parse(time.RFC3339Nano, first.Get("x-deadline")[0])
+	assert.NoError(t, err)
+	assert.WithinDuration(t, before.Add(time.Second), deadline, 100*time.Millisecond)
+	assert.Empty(t, second.Get("x-priority"))
+	assert.Empty(t, second.Get("x-deadline"))
+}
@@ -0,0 +1,315 @@
+package synthetic_load
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// A ContextRunner that sends the input of every query as the body of an HTTP
+// request.
+type HTTPRunner struct {
+	method         string
+	url            *template.Template
+	header         http.Header
+	contentType    string
+	timeout        time.Duration
+	success        func(statusCode int) bool
+	transport      *http.Transport
+	http2          bool
+	corpus         *Corpus
+	priorityHeader string
+	deadlineHeader string
+	client         *http.Client
+}
+
+type HTTPOption func(*HTTPRunner)
+
+// The request method, POST by default.
+func HTTPMethod(method string) HTTPOption {
+	return func(r *HTTPRunner) {
+		r.method = method
+	}
+}
+
+// Adds a header to every request.
+func HTTPHeader(key, value string) HTTPOption {
+	return func(r *HTTPRunner) {
+		r.header.Add(key, value)
+	}
+}
+
+// The content type of the request body, application/octet-stream by default.
+func HTTPContentType(contentType string) HTTPOption {
+	return func(r *HTTPRunner) {
+		r.contentType = contentType
+	}
+}
+
+// The timeout of every request, on top of the replay's query timeout.
+func HTTPTimeout(d time.Duration) HTTPOption {
+	return func(r *HTTPRunner) {
+		r.timeout = d
+	}
+}
+
+// Decides which status codes count as a success, 2xx by default.
+func HTTPSuccess(success func(statusCode int) bool) HTTPOption {
+	return func(r *HTTPRunner) {
+		r.success = success
+	}
+}
+
+// The status codes that count as a success.
+func HTTPSuccessCodes(codes ...int) HTTPOption {
+	return HTTPSuccess(func(statusCode int) bool {
+		for _, code := range codes {
+			if statusCode == code {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// The number of idle keep-alive connections kept to the endpoint.
+func HTTPMaxIdleConns(n int) HTTPOption {
+	return func(r *HTTPRunner) {
+		r.transport.MaxIdleConns = n
+		r.transport.MaxIdleConnsPerHost = n
+	}
+}
+
+// How long an idle keep-alive connection is kept.
+func HTTPIdleConnTimeout(d time.Duration) HTTPOption {
+	return func(r *HTTPRunner) {
+		r.transport.IdleConnTimeout = d
+	}
+}
+
+// Whether connections are reused between requests, true by default.
+func HTTPKeepAlive(keepAlive bool) HTTPOption {
+	return func(r *HTTPRunner) {
+		r.transport.DisableKeepAlives = !keepAlive
+	}
+}
+
+// Whether to negotiate HTTP/2 with TLS endpoints.
+func HTTP2(enabled bool) HTTPOption {
+	return func(r *HTTPRunner) {
+		r.http2 = enabled
+	}
+}
+
+// The TLS configuration used for https endpoints.
+func HTTPTLSConfig(config *tls.Config) HTTPOption {
+	return func(r *HTTPRunner) {
+		r.transport.TLSClientConfig = config
+	}
+}
+
+// Takes the path and headers of every request from the corpus entry of the
+// query's InputIndex. The path is resolved against the runner's URL.
+func HTTPCorpus(corpus *Corpus) HTTPOption {
+	return func(r *HTTPRunner) {
+		r.corpus = corpus
+	}
+}
+
+// The headers the priority and the absolute deadline of a query are forwarded
+// in, X-Priority and X-Deadline by default. The deadline is in RFC 3339 format.
+// An empty name leaves the header out.
+func HTTPPriorityHeaders(priorityHeader, deadlineHeader string) HTTPOption {
+	return func(r *HTTPRunner) {
+		r.priorityHeader = priorityHeader
+		r.deadlineHeader = deadlineHeader
+	}
+}
+
+// Creates an HTTPRunner for the given URL. The URL is a text/template executed
+// with the query's TraceEntry, so that it can refer to e.g. {{.InputIndex}}.
+func NewHTTPRunner(url string, opts ...HTTPOption) (*HTTPRunner, error) {
+	tmpl, err := template.New("url").Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &HTTPRunner{
+		method:         http.MethodPost,
+		url:            tmpl,
+		header:         http.Header{},
+		contentType:    "application/octet-stream",
+		priorityHeader: "X-Priority",
+		deadlineHeader: "X-Deadline",
+		success: func(statusCode int) bool {
+			return statusCode >= 200 && statusCode < 300
+		},
+		transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+	for _, o := range opts {
+		o(r)
+	}
+
+	if r.http2 {
+		if err := http2.ConfigureTransport(r.transport); err != nil {
+			return nil, err
+		}
+	}
+	r.client = &http.Client{
+		Transport: r.transport,
+		Timeout:   r.timeout,
+	}
+
+	return r, nil
+}
+
+func (r *HTTPRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	req, err := r.newRequest(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	timings := &httpTimings{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), timings.trace()))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		onFinish(Completion{Err: err, Timings: timings.finish()})
+		return nil
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err == nil && !r.success(resp.StatusCode) {
+		err = fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	onFinish(Completion{
+		Response: body,
+		Err:      err,
+		Timings:  timings.finish(),
+	})
+
+	return nil
+}
+
+func (r *HTTPRunner) newRequest(ctx context.Context, q Query) (*http.Request, error) {
+	var url strings.Builder
+	if err := r.url.Execute(&url, q.TraceEntry); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(r.method, url.String(), bytes.NewReader(q.Input))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range r.header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	// corpus entries may set their own content type
+	req.Header.Set("Content-Type", r.contentType)
+	if r.corpus != nil {
+		request := r.corpus.Request(q.InputIndex)
+		if request.Path != "" {
+			path, err := neturl.Parse(request.Path)
+			if err != nil {
+				return nil, err
+			}
+			req.URL = req.URL.ResolveReference(path)
+		}
+		for key, values := range request.Header {
+			req.Header[key] = append([]string(nil), values...)
+		}
+	}
+	if q.Priority != 0 && r.priorityHeader != "" {
+		req.Header.Set(r.priorityHeader, strconv.Itoa(q.Priority))
+	}
+	if deadline, ok := q.DeadlineTime(); ok && r.deadlineHeader != "" {
+		req.Header.Set(r.deadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+	}
+
+	return req.WithContext(ctx), nil
+}
+
+// Collects the phases of an HTTP request. The trace hooks may fire from
+// different goroutines.
+type httpTimings struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	timings      map[string]time.Duration
+}
+
+func (t *httpTimings) trace() *httptrace.ClientTrace {
+	t.start = time.Now()
+	t.timings = map[string]time.Duration{}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.timings["dns"] = time.Since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(network, addr string) {
+			t.mu.Lock()
+			t.connectStart = time.Now()
+			t.mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mu.Lock()
+			t.timings["connect"] = time.Since(t.connectStart)
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.timings["tls"] = time.Since(t.tlsStart)
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.timings["ttfb"] = time.Since(t.start)
+			t.mu.Unlock()
+		},
+	}
+}
+
+func (t *httpTimings) finish() map[string]time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timings["total"] = time.Since(t.start)
+	return t.timings
+}
@@ -0,0 +1,83 @@
+package synthetic_load
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPRunner(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/predict/3" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "image/jpeg", req.Header.Get("Content-Type"))
+		assert.Equal(t, "synthetic", req.Header.Get("X-Client"))
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	runner, err := NewHTTPRunner(
+		server.URL+"/predict/{{.InputIndex}}",
+		HTTPContentType("image/jpeg"),
+		HTTPHeader("X-Client", "synthetic"),
+	)
+	assert.NoError(t, err)
+
+	trace := Trace{
+		{Index: 0, InputIndex: 1},
+		{Index: 1, InputIndex: 2},
+		{Index: 2, InputIndex: 3},
+	}
+	result, err := trace.Measure(
+		InputContextRunner(runner),
+		InputGenerator(func(idx int) ([]byte, error) {
+			return []byte("input"), nil
+		}),
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, result.Queries[0].Err)
+	assert.NoError(t, result.Queries[1].Err)
+	assert.Error(t, result.Queries[2].Err)
+	for _, q := range result.Queries {
+		assert.Contains(t, q.Timings, "ttfb")
+		assert.Contains(t, q.Timings, "total")
+	}
+}
+
+func TestHTTPRunnerForwardsPriority(t *testing.T) {
+	headers := make(chan http.Header, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		headers <- req.Header
+	}))
+	defer server.Close()
+
+	runner, err := NewHTTPRunner(server.URL)
+	assert.NoError(t, err)
+
+	trace := Trace{
+		{Index: 0, InputIndex: 1, Priority: 2, Deadline: time.Second},
+		{Index: 1, InputIndex: 2},
+	}
+	before := time.Now()
+	result, err := trace.Measure(InputContextRunner(runner))
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, result.DeadlineMissRate())
+
+	first, second := <-headers, <-headers
+	if first.Get("X-Priority") == "" {
+		first, second = second, first
+	}
+	assert.Equal(t, "2", first.Get("X-Priority"))
+	deadline, err := time.Parse(time.RFC3339Nano, first.Get("X-Deadline"))
+	assert.NoError(t, err)
+	assert.WithinDuration(t, before.Add(time.Second), deadline, 100*time.Millisecond)
+	assert.Empty(t, second.Get("X-Priority"))
+	assert.Empty(t, second.Get("X-Deadline"))
+}
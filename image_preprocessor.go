@@ -0,0 +1,233 @@
+package synthetic_load
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+)
+
+// The order of the dimensions of an image tensor.
+type TensorLayout int
+
+const (
+	// Batch, channels, height, width.
+	LayoutNCHW TensorLayout = iota
+	// Batch, height, width, channels.
+	LayoutNHWC
+)
+
+// The element type of an image tensor.
+type TensorDType int
+
+const (
+	// Normalized values, little-endian.
+	TensorFloat32 TensorDType = iota
+	// Raw pixel values, not normalized.
+	TensorUint8
+)
+
+// An input generator that turns the JPEG or PNG images of another generator
+// into the tensor a vision model takes: the image is decoded, resized so that
+// its shorter side has the given length, center-cropped, normalized with the
+// per-channel mean and standard deviation, and laid out as a batch of one.
+//
+// With a tensor header, the tensor is preceded by the magic "TNSR", a byte
+// holding the TensorDType, a byte holding the rank and a little-endian uint32
+// per dimension.
+type ImagePreprocessor struct {
+	source func(int) ([]byte, error)
+	resize int
+	width  int
+	height int
+	mean   [3]float64
+	std    [3]float64
+	layout TensorLayout
+	dtype  TensorDType
+	header bool
+}
+
+type ImageOption func(*ImagePreprocessor)
+
+// The length of the shorter side the image is resized to before cropping, 256
+// by default.
+func ImageResize(shorterSide int) ImageOption {
+	return func(p *ImagePreprocessor) {
+		p.resize = shorterSide
+	}
+}
+
+// The size of the center crop, 224x224 by default.
+func ImageCrop(width, height int) ImageOption {
+	return func(p *ImagePreprocessor) {
+		p.width = width
+		p.height = height
+	}
+}
+
+// The per-channel mean and standard deviation of the RGB values scaled to
+// [0, 1], e.g. those of ImageNet. By default values are only scaled.
+func ImageNormalize(mean, std [3]float64) ImageOption {
+	return func(p *ImagePreprocessor) {
+		p.mean = mean
+		p.std = std
+	}
+}
+
+// The layout of the tensor, NCHW by default.
+func ImageLayout(layout TensorLayout) ImageOption {
+	return func(p *ImagePreprocessor) {
+		p.layout = layout
+	}
+}
+
+// The element type of the tensor, float32 by default.
+func ImageDType(dtype TensorDType) ImageOption {
+	return func(p *ImagePreprocessor) {
+		p.dtype = dtype
+	}
+}
+
+// Precedes the tensor with a header describing its type and shape.
+func ImageTensorHeader() ImageOption {
+	return func(p *ImagePreprocessor) {
+		p.header = true
+	}
+}
+
+// Creates a preprocessor of the images of the source generator, such as the
+// Generate method of a DatasetInputGenerator. A nil source uses the embedded
+// images.
+func NewImagePreprocessor(source func(int) ([]byte, error), opts ...ImageOption) *ImagePreprocessor {
+	if source == nil {
+		source = fixtureInput
+	}
+	p := &ImagePreprocessor{
+		source: source,
+		resize: 256,
+		width:  224,
+		height: 224,
+		std:    [3]float64{1, 1, 1},
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	return p
+}
+
+func (p *ImagePreprocessor) Generate(idx int) ([]byte, error) {
+	buf, err := p.source(idx)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	pixels := p.resizeAndCrop(img)
+
+	var out bytes.Buffer
+	if p.header {
+		p.writeHeader(&out)
+	}
+	if p.dtype == TensorUint8 {
+		out.Write(p.arrange(pixels, func(c int, v float64) []byte {
+			return []byte{uint8(math.Round(v))}
+		}, 1))
+		return out.Bytes(), nil
+	}
+	out.Write(p.arrange(pixels, func(c int, v float64) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32((v/255-p.mean[c])/p.std[c])))
+		return b
+	}, 4))
+	return out.Bytes(), nil
+}
+
+// The shape of the tensor.
+func (p *ImagePreprocessor) Shape() []int {
+	if p.layout == LayoutNHWC {
+		return []int{1, p.height, p.width, 3}
+	}
+	return []int{1, 3, p.height, p.width}
+}
+
+func (p *ImagePreprocessor) writeHeader(out *bytes.Buffer) {
+	shape := p.Shape()
+	out.WriteString("TNSR")
+	out.WriteByte(byte(p.dtype))
+	out.WriteByte(byte(len(shape)))
+	for _, dim := range shape {
+		binary.Write(out, binary.LittleEndian, uint32(dim))
+	}
+}
+
+// Resizes with bilinear interpolation and crops in a single pass, returning the
+// RGB values of the crop in height, width, channel order.
+func (p *ImagePreprocessor) resizeAndCrop(img image.Image) []float64 {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	w, h := bounds.Dx(), bounds.Dy()
+
+	scale := float64(p.resize) / math.Min(float64(w), float64(h))
+	// the resized image must cover the crop
+	scale = math.Max(scale, math.Max(float64(p.width)/float64(w), float64(p.height)/float64(h)))
+	offsetX := (float64(w)*scale - float64(p.width)) / 2
+	offsetY := (float64(h)*scale - float64(p.height)) / 2
+
+	at := func(x, y, c int) float64 {
+		return float64(rgba.Pix[y*rgba.Stride+x*4+c])
+	}
+	clamp := func(v, max int) int {
+		if v < 0 {
+			return 0
+		}
+		if v > max {
+			return max
+		}
+		return v
+	}
+
+	pixels := make([]float64, 0, p.width*p.height*3)
+	for y := 0; y < p.height; y++ {
+		sy := (float64(y)+offsetY+0.5)/scale - 0.5
+		y0 := int(math.Floor(sy))
+		fy := sy - float64(y0)
+		y1 := clamp(y0+1, h-1)
+		y0 = clamp(y0, h-1)
+		for x := 0; x < p.width; x++ {
+			sx := (float64(x)+offsetX+0.5)/scale - 0.5
+			x0 := int(math.Floor(sx))
+			fx := sx - float64(x0)
+			x1 := clamp(x0+1, w-1)
+			x0 = clamp(x0, w-1)
+			for c := 0; c < 3; c++ {
+				top := at(x0, y0, c)*(1-fx) + at(x1, y0, c)*fx
+				bottom := at(x0, y1, c)*(1-fx) + at(x1, y1, c)*fx
+				pixels = append(pixels, top*(1-fy)+bottom*fy)
+			}
+		}
+	}
+	return pixels
+}
+
+// Encodes the pixels in the tensor's layout.
+func (p *ImagePreprocessor) arrange(pixels []float64, encode func(c int, v float64) []byte, size int) []byte {
+	out := make([]byte, 0, len(pixels)*size)
+	if p.layout == LayoutNHWC {
+		for ii, v := range pixels {
+			out = append(out, encode(ii%3, v)...)
+		}
+		return out
+	}
+	for c := 0; c < 3; c++ {
+		for ii := c; ii < len(pixels); ii += 3 {
+			out = append(out, encode(c, pixels[ii])...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package synthetic_load
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImagePreprocessor(t *testing.T) {
+	p := NewImagePreprocessor(nil)
+	tensor, err := p.Generate(0)
+	assert.NoError(t, err)
+	assert.Len(t, tensor, 3*224*224*4)
+
+	p = NewImagePreprocessor(nil, ImageCrop(32, 16), ImageResize(40), ImageDType(TensorUint8), ImageLayout(LayoutNHWC), ImageTensorHeader())
+	tensor, err = p.Generate(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "TNSR", string(tensor[:4]))
+	assert.Equal(t, []byte{byte(TensorUint8), 4}, tensor[4:6])
+	shape := make([]uint32, 4)
+	binary.Read(bytes.NewReader(tensor[6:22]), binary.LittleEndian, shape)
+	assert.Equal(t, []uint32{1, 16, 32, 3}, shape)
+	assert.Len(t, tensor, 22+16*32*3)
+}
+
+func TestImagePreprocessorNormalize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{R: 255, G: 51, B: 0, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+
+	p := NewImagePreprocessor(func(int) ([]byte, error) {
+		return buf.Bytes(), nil
+	}, ImageCrop(2, 2), ImageResize(2), ImageNormalize([3]float64{0.5, 0.2, 0}, [3]float64{0.5, 0.1, 1}))
+	tensor, err := p.Generate(0)
+	assert.NoError(t, err)
+
+	values := make([]float32, 12)
+	binary.Read(bytes.NewReader(tensor), binary.LittleEndian, values)
+	// NCHW, so each channel's four pixels are contiguous
+	for ii, expected := range []float64{1, 0, 0} {
+		for jj := 0; jj < 4; jj++ {
+			assert.True(t, math.Abs(float64(values[ii*4+jj])-expected) < 1e-5, "channel %d: %v", ii, values[ii*4+jj])
+		}
+	}
+}
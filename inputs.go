@@ -0,0 +1,103 @@
+package synthetic_load
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// Holds generated inputs by class and input index within a memory budget,
+// evicting the least recently used ones.
+type inputCache struct {
+	options *Options
+
+	mu      sync.Mutex
+	size    int64
+	entries map[inputKey]*list.Element
+	lru     *list.List
+}
+
+// Classes of a workload may have different inputs for the same index.
+type inputKey struct {
+	class string
+	idx   int
+}
+
+type inputEntry struct {
+	key   inputKey
+	input []byte
+}
+
+func newInputCache(options *Options) *inputCache {
+	return &inputCache{
+		options: options,
+		entries: map[inputKey]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Generates the inputs needed first before the clock starts, until the budget
+// is full, so that a failing generator is a setup error. The others are
+// generated when they are needed.
+func (c *inputCache) prepare(trace Trace) error {
+	for _, tr := range trace {
+		key := inputKey{class: tr.Class, idx: tr.InputIndex}
+		if _, ok := c.entries[key]; ok {
+			continue
+		}
+		input, err := c.options.inputGeneratorFor(tr)(tr.InputIndex)
+		if err != nil {
+			return fmt.Errorf("unable to generate input %d: %v", tr.InputIndex, err)
+		}
+		if c.size+int64(len(input)) > c.options.inputBudget {
+			return nil
+		}
+		c.add(tr, input)
+	}
+	return nil
+}
+
+// Returns the input of the entry, generating it if it was evicted.
+func (c *inputCache) get(tr TraceEntry) ([]byte, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[inputKey{class: tr.Class, idx: tr.InputIndex}]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*inputEntry).input, nil
+	}
+	c.mu.Unlock()
+
+	input, err := c.options.inputGeneratorFor(tr)(tr.InputIndex)
+	if err != nil {
+		return nil, err
+	}
+	c.add(tr, input)
+	return input, nil
+}
+
+func (c *inputCache) add(tr TraceEntry, input []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := inputKey{class: tr.Class, idx: tr.InputIndex}
+	budget := c.options.inputBudget
+	if _, ok := c.entries[key]; ok || int64(len(input)) > budget {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&inputEntry{key: key, input: input})
+	c.size += int64(len(input))
+	for c.size > budget {
+		e := c.lru.Back()
+		entry := e.Value.(*inputEntry)
+		c.lru.Remove(e)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.input))
+	}
+}
+
+func (trace Trace) prepareInputs(options *Options) (*inputCache, error) {
+	inputs := newInputCache(options)
+	if err := inputs.prepare(trace); err != nil {
+		return nil, err
+	}
+	return inputs, nil
+}
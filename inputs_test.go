@@ -0,0 +1,82 @@
+package synthetic_load
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInputsGeneratedBeforeReplay(t *testing.T) {
+	var calls int64
+	slowGenerator := InputGenerator(func(idx int) ([]byte, error) {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return []byte{byte(idx)}, nil
+	})
+	trace := Trace{
+		{Index: 0, InputIndex: 1},
+		{Index: 1, InputIndex: 2, TimeStamp: time.Millisecond},
+		{Index: 2, InputIndex: 1, TimeStamp: 2 * time.Millisecond},
+	}
+
+	result, err := trace.Measure(slowGenerator, InputContextRunner(echoRunner{}))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, calls)
+	for _, q := range result.Queries {
+		assert.True(t, q.IssueLag < 5*time.Millisecond, "issue lag %v", q.IssueLag)
+		assert.True(t, q.Latency < 5*time.Millisecond, "latency %v", q.Latency)
+	}
+
+	// inputs beyond the budget are generated before their queries are timed
+	result, err = trace.Measure(slowGenerator, InputBudget(0), InputContextRunner(echoRunner{}))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Completed())
+	for _, q := range result.Queries {
+		// generating an input takes 10ms
+		assert.True(t, q.Latency < 10*time.Millisecond, "latency %v", q.Latency)
+	}
+}
+
+func TestInputGeneratorErrorIsSetupError(t *testing.T) {
+	trace := Trace{{Index: 0, InputIndex: 3}}
+	failing := InputGenerator(func(idx int) ([]byte, error) {
+		return nil, errors.New("missing file")
+	})
+
+	_, err := trace.Measure(failing)
+	assert.Error(t, err)
+	_, err = trace.Measure(failing, VirtualTime())
+	assert.Error(t, err)
+	_, err = RunClosedLoop(failing, MinQueries(1), MinDuration(0))
+	assert.Error(t, err)
+}
+
+func TestInputCacheBudget(t *testing.T) {
+	var calls int64
+	inputs := newInputCache(NewOptions(
+		InputBudget(2),
+		InputGenerator(func(idx int) ([]byte, error) {
+			atomic.AddInt64(&calls, 1)
+			return []byte{byte(idx)}, nil
+		}),
+	))
+	// generation stops at the first input over the budget
+	assert.NoError(t, inputs.prepare(Trace{{InputIndex: 1}, {InputIndex: 2}, {InputIndex: 3}, {InputIndex: 4}, {InputIndex: 1}}))
+	assert.EqualValues(t, 3, calls)
+
+	// the inputs needed first are kept
+	input, _ := inputs.get(TraceEntry{InputIndex: 1})
+	assert.Equal(t, []byte{1}, input)
+	inputs.get(TraceEntry{InputIndex: 2})
+	assert.EqualValues(t, 3, calls)
+	inputs.get(TraceEntry{InputIndex: 3})
+	assert.EqualValues(t, 4, calls)
+	// 3 evicted the least recently used input, 1
+	inputs.get(TraceEntry{InputIndex: 2})
+	assert.EqualValues(t, 4, calls)
+	inputs.get(TraceEntry{InputIndex: 1})
+	assert.EqualValues(t, 5, calls)
+}
@@ -0,0 +1,449 @@
+package synthetic_load
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/seehuhn/mt19937"
+)
+
+var (
+	// Reported for queries rejected while the circuit breaker is open.
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	// Reported for queries failed by fault injection.
+	ErrInjectedFault = errors.New("injected fault")
+)
+
+// Wraps a ContextRunner to change how queries reach it.
+type Middleware func(ContextRunner) ContextRunner
+
+// Wraps the runner with the middlewares, the first one being the outermost.
+func Chain(runner ContextRunner, middlewares ...Middleware) ContextRunner {
+	for ii := len(middlewares) - 1; ii >= 0; ii-- {
+		runner = middlewares[ii](runner)
+	}
+	return runner
+}
+
+// The counters of a wrapper, reported along with those of the runner it wraps.
+type wrapperCounters struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func (c *wrapperCounters) add(name string, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values == nil {
+		c.values = map[string]int64{}
+	}
+	c.values[name] += n
+}
+
+func (c *wrapperCounters) merged(inner ContextRunner) map[string]int64 {
+	counters := map[string]int64{}
+	for name, value := range runnerCounters(inner) {
+		counters[name] = value
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, value := range c.values {
+		counters[name] += value
+	}
+	return counters
+}
+
+// Reissues failed queries up to the given number of attempts in total,
+// doubling the backoff between attempts. Counts "retry.retries" and
+// "retry.exhausted".
+func Retry(attempts int, backoff time.Duration) Middleware {
+	return func(inner ContextRunner) ContextRunner {
+		return &retryRunner{inner: inner, attempts: attempts, backoff: backoff}
+	}
+}
+
+type retryRunner struct {
+	wrapperCounters
+	inner    ContextRunner
+	attempts int
+	backoff  time.Duration
+}
+
+func (r *retryRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	var attempt func(n int, backoff time.Duration)
+	attempt = func(n int, backoff time.Duration) {
+		completed := func(c Completion) {
+			if c.Err == nil || ctx.Err() != nil {
+				onFinish(c)
+				return
+			}
+			if n+1 >= r.attempts {
+				r.add("retry.exhausted", 1)
+				onFinish(c)
+				return
+			}
+			r.add("retry.retries", 1)
+			go func() {
+				select {
+				case <-time.After(backoff):
+					attempt(n+1, 2*backoff)
+				case <-ctx.Done():
+					onFinish(Completion{Err: ctx.Err()})
+				}
+			}()
+		}
+		if err := r.inner.RunContext(ctx, q, completed); err != nil {
+			completed(Completion{Err: err})
+		}
+	}
+	attempt(0, r.backoff)
+	return nil
+}
+
+func (r *retryRunner) Counters() map[string]int64 {
+	return r.merged(r.inner)
+}
+
+// Sends a second copy of a query once it has been outstanding for longer than
+// the given percentile of recent latencies, and takes whichever successful
+// response comes first. Counts "hedge.sent" and "hedge.won".
+func Hedge(percentile float64) Middleware {
+	if percentile > 1.0 {
+		percentile = percentile / 100.0
+	}
+	return func(inner ContextRunner) ContextRunner {
+		return &hedgeRunner{inner: inner, percentile: percentile}
+	}
+}
+
+type hedgeRunner struct {
+	wrapperCounters
+	inner      ContextRunner
+	percentile float64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+	delay     time.Duration
+}
+
+const (
+	// the number of recent latencies the hedging delay is estimated from
+	hedgeWindow = 1024
+	// how many new latencies are recorded between estimates
+	hedgeRefresh = 64
+)
+
+func (r *hedgeRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	var once sync.Once
+	// guards the copies in flight, the first failure and the timer
+	var mu sync.Mutex
+	var finished bool
+	inFlight := 0
+	var failure *Completion
+	var timer *time.Timer
+	finish := func(c Completion, won bool) {
+		once.Do(func() {
+			mu.Lock()
+			finished = true
+			if timer != nil {
+				timer.Stop()
+			}
+			mu.Unlock()
+			// the slower copy is no longer needed
+			cancel()
+			if won {
+				r.add("hedge.won", 1)
+			}
+			onFinish(c)
+		})
+	}
+
+	send := func(hedged bool) {
+		start := time.Now()
+		// a runner may both call back and fail
+		var sent sync.Once
+		completed := func(c Completion) {
+			sent.Do(func() {
+				if c.Err == nil {
+					r.record(time.Since(start))
+					finish(c, hedged)
+					return
+				}
+				// a failure only counts once no other copy may still succeed
+				mu.Lock()
+				inFlight--
+				if failure == nil {
+					failure = &c
+				}
+				first := *failure
+				waiting := inFlight > 0
+				mu.Unlock()
+				if !waiting {
+					finish(first, false)
+				}
+			})
+		}
+		if err := r.inner.RunContext(ctx, q, completed); err != nil {
+			completed(Completion{Err: err})
+		}
+	}
+
+	// the timer is armed first so that runners completing synchronously can
+	// be hedged too
+	mu.Lock()
+	inFlight++
+	if delay := r.hedgeDelay(); delay > 0 {
+		timer = time.AfterFunc(delay, func() {
+			mu.Lock()
+			if finished || inFlight == 0 {
+				mu.Unlock()
+				return
+			}
+			inFlight++
+			mu.Unlock()
+			r.add("hedge.sent", 1)
+			send(true)
+		})
+	}
+	mu.Unlock()
+	send(false)
+
+	return nil
+}
+
+func (r *hedgeRunner) Counters() map[string]int64 {
+	return r.merged(r.inner)
+}
+
+func (r *hedgeRunner) record(latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.latencies) < hedgeWindow {
+		r.latencies = append(r.latencies, latency)
+	} else {
+		r.latencies[r.next%hedgeWindow] = latency
+	}
+	r.next++
+	if r.next%hedgeRefresh == 0 {
+		sorted := append([]time.Duration(nil), r.latencies...)
+		sort.Slice(sorted, func(ii, jj int) bool {
+			return sorted[ii] < sorted[jj]
+		})
+		r.delay = percentile(sorted, r.percentile)
+	}
+}
+
+// The delay after which a copy is sent, or 0 until enough latencies are known.
+func (r *hedgeRunner) hedgeDelay() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.delay
+}
+
+// Delays queries so that at most qps of them reach the runner per second, with
+// bursts of up to burst queries (a token bucket). Counts "rate_limit.delayed"
+// and reports the delay as the "rate_limit_wait" timing.
+func RateLimit(qps float64, burst int) Middleware {
+	return func(inner ContextRunner) ContextRunner {
+		return &rateLimitRunner{
+			inner:  inner,
+			rate:   qps,
+			burst:  float64(burst),
+			tokens: float64(burst),
+		}
+	}
+}
+
+type rateLimitRunner struct {
+	wrapperCounters
+	inner ContextRunner
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (r *rateLimitRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	wait := r.reserve()
+	if wait > 0 {
+		r.add("rate_limit.delayed", 1)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			r.release()
+			return ctx.Err()
+		}
+	}
+	return r.inner.RunContext(ctx, q, func(c Completion) {
+		timings := map[string]time.Duration{"rate_limit_wait": wait}
+		for name, t := range c.Timings {
+			timings[name] = t
+		}
+		c.Timings = timings
+		onFinish(c)
+	})
+}
+
+func (r *rateLimitRunner) Counters() map[string]int64 {
+	return r.merged(r.inner)
+}
+
+// Takes a token and returns how long to wait until it is available.
+func (r *rateLimitRunner) reserve() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if !r.last.IsZero() {
+		r.tokens = math.Min(r.burst, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	}
+	r.last = now
+	r.tokens--
+	if r.tokens >= 0 {
+		return 0
+	}
+	return seconds(-r.tokens / r.rate)
+}
+
+// Returns a token reserved by a query that was never sent.
+func (r *rateLimitRunner) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens = math.Min(r.burst, r.tokens+1)
+}
+
+// Fails queries fast with ErrCircuitOpen once the given number of consecutive
+// queries failed. After the cool-down a single trial query is let through,
+// which closes the breaker if it succeeds. Counts "breaker.opened" and
+// "breaker.rejected".
+func CircuitBreaker(failures int, cooldown time.Duration) Middleware {
+	return func(inner ContextRunner) ContextRunner {
+		return &breakerRunner{inner: inner, threshold: failures, cooldown: cooldown}
+	}
+}
+
+type breakerRunner struct {
+	wrapperCounters
+	inner     ContextRunner
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (r *breakerRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	if !r.allow() {
+		r.add("breaker.rejected", 1)
+		onFinish(Completion{Err: ErrCircuitOpen})
+		return nil
+	}
+	completed := func(c Completion) {
+		r.record(c.Err)
+		onFinish(c)
+	}
+	if err := r.inner.RunContext(ctx, q, completed); err != nil {
+		completed(Completion{Err: err})
+	}
+	return nil
+}
+
+func (r *breakerRunner) Counters() map[string]int64 {
+	return r.merged(r.inner)
+}
+
+func (r *breakerRunner) allow() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.openUntil.IsZero() {
+		return true
+	}
+	if r.probing || time.Now().Before(r.openUntil) {
+		return false
+	}
+	// half open, let a single trial through
+	r.probing = true
+	return true
+}
+
+func (r *breakerRunner) record(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		r.failures = 0
+		r.openUntil = time.Time{}
+		r.probing = false
+		return
+	}
+	r.failures++
+	if r.probing || (r.openUntil.IsZero() && r.failures >= r.threshold) {
+		r.probing = false
+		r.openUntil = time.Now().Add(r.cooldown)
+		r.add("breaker.opened", 1)
+	}
+}
+
+// Adds the given latency to a query with probability latencyProbability, and
+// fails a query with ErrInjectedFault with probability errorProbability.
+// Counts "fault.latency" and "fault.error".
+func InjectFaults(latencyProbability float64, latency time.Duration, errorProbability float64, seed int64) Middleware {
+	return func(inner ContextRunner) ContextRunner {
+		mt := mt19937.New()
+		mt.Seed(seed)
+		return &faultRunner{
+			inner:              inner,
+			latencyProbability: latencyProbability,
+			latency:            latency,
+			errorProbability:   errorProbability,
+			rng:                rand.New(mt),
+		}
+	}
+}
+
+type faultRunner struct {
+	wrapperCounters
+	inner              ContextRunner
+	latencyProbability float64
+	latency            time.Duration
+	errorProbability   float64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func (r *faultRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	r.mu.Lock()
+	delay := r.rng.Float64() < r.latencyProbability
+	fail := r.rng.Float64() < r.errorProbability
+	r.mu.Unlock()
+
+	if delay {
+		r.add("fault.latency", 1)
+		select {
+		case <-time.After(r.latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if fail {
+		r.add("fault.error", 1)
+		onFinish(Completion{Err: ErrInjectedFault})
+		return nil
+	}
+	return r.inner.RunContext(ctx, q, onFinish)
+}
+
+func (r *faultRunner) Counters() map[string]int64 {
+	return r.merged(r.inner)
+}
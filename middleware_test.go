@@ -0,0 +1,152 @@
+package synthetic_load
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Fails the first failures attempts of every query and optionally delays the
+// first attempt of every tenth query.
+type flakyRunner struct {
+	failures int
+	slow     time.Duration
+
+	mu       sync.Mutex
+	attempts map[int]int
+}
+
+func (r *flakyRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	r.mu.Lock()
+	if r.attempts == nil {
+		r.attempts = map[int]int{}
+	}
+	attempt := r.attempts[q.Index]
+	r.attempts[q.Index]++
+	r.mu.Unlock()
+
+	go func() {
+		if attempt < r.failures {
+			onFinish(Completion{Err: errors.New("unavailable")})
+			return
+		}
+		delay := time.Millisecond
+		if attempt == 0 && q.Index%10 == 0 {
+			delay = r.slow
+		}
+		time.Sleep(delay)
+		onFinish(Completion{})
+	}()
+	return nil
+}
+
+func TestRetry(t *testing.T) {
+	result, err := burstTrace(8).Measure(
+		InputContextRunner(Chain(&flakyRunner{failures: 2}, Retry(3, time.Millisecond))),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, result.Completed())
+	assert.Equal(t, int64(16), result.Counters["retry.retries"])
+	assert.Equal(t, int64(0), result.Counters["retry.exhausted"])
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	runner := Chain(&flakyRunner{failures: 100}, CircuitBreaker(3, time.Hour))
+	errs := []error{}
+	for ii := 0; ii < 10; ii++ {
+		done := make(chan error, 1)
+		runner.RunContext(context.Background(), Query{}, func(c Completion) {
+			done <- c.Err
+		})
+		errs = append(errs, <-done)
+	}
+	assert.NotEqual(t, ErrCircuitOpen, errs[2])
+	assert.Equal(t, ErrCircuitOpen, errs[3])
+	assert.Equal(t, map[string]int64{"breaker.opened": 1, "breaker.rejected": 7},
+		runner.(CountingRunner).Counters())
+}
+
+func TestRateLimitAndFaults(t *testing.T) {
+	result, err := burstTrace(5).Measure(
+		InputContextRunner(Chain(
+			&flakyRunner{},
+			RateLimit(100, 1),
+			InjectFaults(0, 0, 1, 0),
+		)),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, result.ErrorRate())
+	assert.Equal(t, int64(4), result.Counters["rate_limit.delayed"])
+	assert.Equal(t, int64(5), result.Counters["fault.error"])
+	assert.True(t, result.TimingPercentile("rate_limit_wait", 1) >= 35*time.Millisecond)
+}
+
+func TestHedge(t *testing.T) {
+	trace := NewTrace(QPS(1000), MinQueries(500), MinDuration(0))
+	result, err := trace.Measure(
+		InputContextRunner(Chain(&flakyRunner{slow: 100 * time.Millisecond}, Hedge(0.9))),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 500, result.Completed())
+	assert.True(t, result.Counters["hedge.sent"] > 0)
+	assert.True(t, result.Counters["hedge.won"] > 0)
+}
+
+// Fails the first attempt after 20ms and answers any other after 30ms.
+type slowFailureRunner struct {
+	mu       sync.Mutex
+	attempts int
+}
+
+func (r *slowFailureRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	r.mu.Lock()
+	attempt := r.attempts
+	r.attempts++
+	r.mu.Unlock()
+
+	go func() {
+		if attempt == 0 {
+			time.Sleep(20 * time.Millisecond)
+			onFinish(Completion{Err: errors.New("unavailable")})
+			return
+		}
+		time.Sleep(30 * time.Millisecond)
+		onFinish(Completion{})
+	}()
+	return nil
+}
+
+func TestHedgePrefersSuccess(t *testing.T) {
+	runner := Hedge(0.9)(&slowFailureRunner{}).(*hedgeRunner)
+	runner.delay = 5 * time.Millisecond
+
+	done := make(chan Completion, 1)
+	runner.RunContext(context.Background(), Query{}, func(c Completion) {
+		done <- c
+	})
+	assert.NoError(t, (<-done).Err)
+	assert.Equal(t, map[string]int64{"hedge.sent": 1, "hedge.won": 1}, runner.Counters())
+
+	// without a copy left, the failure is reported
+	runner = Hedge(0.9)(&flakyRunner{failures: 1}).(*hedgeRunner)
+	runner.RunContext(context.Background(), Query{}, func(c Completion) {
+		done <- c
+	})
+	assert.Error(t, (<-done).Err)
+}
+
+func TestRateLimitReturnsCancelledTokens(t *testing.T) {
+	runner := RateLimit(10, 1)(&flakyRunner{}).(*rateLimitRunner)
+	assert.NoError(t, runner.RunContext(context.Background(), Query{}, func(Completion) {}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, runner.RunContext(ctx, Query{}, func(Completion) {}))
+
+	// the next query only waits for the token the first one took
+	assert.True(t, runner.reserve() <= 100*time.Millisecond)
+}
@@ -0,0 +1,97 @@
+package synthetic_load
+
+import (
+	"math"
+	"time"
+)
+
+// An M/M/c queue: Poisson arrivals, exponential service times and c servers.
+// It gives the theoretical latencies a SimulatedServerRunner with exponential
+// service times should match.
+type MMc struct {
+	Servers int
+	// Arrivals per second.
+	ArrivalRate float64
+	// Completions per second of a single server.
+	ServiceRate float64
+}
+
+// The fraction of time the servers are busy. The queue is only stable below 1.
+func (q MMc) Utilization() float64 {
+	return q.ArrivalRate / (float64(q.Servers) * q.ServiceRate)
+}
+
+// The probability that an arriving query has to wait (Erlang's C formula).
+func (q MMc) WaitProbability() float64 {
+	if q.Utilization() >= 1 {
+		return 1
+	}
+	a := q.ArrivalRate / q.ServiceRate
+	// Erlang's B formula by recursion over the number of servers
+	b := 1.0
+	for k := 1; k <= q.Servers; k++ {
+		b = a * b / (float64(k) + a*b)
+	}
+	c := float64(q.Servers)
+	return c * b / (c - a*(1-b))
+}
+
+// The mean time from arrival to completion.
+func (q MMc) MeanLatency() time.Duration {
+	if q.Utilization() >= 1 {
+		return time.Duration(math.MaxInt64)
+	}
+	wait := q.WaitProbability() / (float64(q.Servers)*q.ServiceRate - q.ArrivalRate)
+	return seconds(wait + 1/q.ServiceRate)
+}
+
+// The probability that a query takes longer than t from arrival to
+// completion.
+func (q MMc) tail(t float64) float64 {
+	mu := q.ServiceRate
+	theta := float64(q.Servers)*mu - q.ArrivalRate
+	waitProbability := q.WaitProbability()
+
+	// the sum of an Exp(theta) wait and an Exp(mu) service
+	waitedTail := (1 + mu*t) * math.Exp(-mu*t)
+	if math.Abs(theta-mu) > 1e-9*mu {
+		waitedTail = (theta*math.Exp(-mu*t) - mu*math.Exp(-theta*t)) / (theta - mu)
+	}
+
+	return (1-waitProbability)*math.Exp(-mu*t) + waitProbability*waitedTail
+}
+
+// The latency below which the given fraction of queries lie.
+func (q MMc) Percentile(p float64) time.Duration {
+	if q.Utilization() >= 1 {
+		return time.Duration(math.MaxInt64)
+	}
+	lower, upper := 0.0, 1/q.ServiceRate
+	for q.tail(upper) > 1-p {
+		upper *= 2
+	}
+	for ii := 0; ii < 100; ii++ {
+		mid := (lower + upper) / 2
+		if q.tail(mid) > 1-p {
+			lower = mid
+		} else {
+			upper = mid
+		}
+	}
+	return seconds(upper)
+}
+
+// The largest arrival rate at which the given fraction of queries completes
+// within the latency bound, i.e. what FindMaxQPS should find.
+func (q MMc) MaxArrivalRate(latencyBound time.Duration, p float64) float64 {
+	lower, upper := 0.0, float64(q.Servers)*q.ServiceRate
+	for ii := 0; ii < 100; ii++ {
+		q.ArrivalRate = (lower + upper) / 2
+		if q.Percentile(p) > latencyBound {
+			upper = q.ArrivalRate
+		} else {
+			lower = q.ArrivalRate
+		}
+	}
+	return lower
+}
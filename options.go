@@ -14,9 +14,33 @@ type Options struct {
 	minDuration            time.Duration
 	latencyBound           time.Duration
 	latencyBoundPercentile float64
-	runner                 Runner
+	runner                 ContextRunner
 	qps                    float64
 	maxQpsSearchIterations int64
+	sweepStartQPS          float64
+	sweepGrowthFactor      float64
+	sweepMaxLevels         int
+	sweepPercentiles       []float64
+	warmup                 time.Duration
+	warmupQueries          int
+	cooldown               time.Duration
+	cooldownQueries        int
+	warmupPhase            time.Duration
+	concurrency            int
+	maxConcurrency         int
+	thinkTime              time.Duration
+	maxInFlight            int
+	overloadPolicy         OverloadPolicy
+	maxQueueLength         int
+	queryTimeout           time.Duration
+	virtualTime            bool
+	inputBudget            int64
+	workload               *Workload
+	arrivals               ArrivalProcess
+	queryPriority          int
+	queryDeadline          time.Duration
+	controller             RateController
+	controlInterval        time.Duration
 }
 
 type Option func(*Options)
@@ -36,6 +60,14 @@ func InputGenerator(inputGenerator func(int) ([]byte, error)) Option {
 
 // The input runner (what's called enqueue function in sylt)
 func InputRunner(runner Runner) Option {
+	return func(o *Options) {
+		o.runner = AdaptRunner(runner)
+	}
+}
+
+// The input runner, for runners that observe cancellation and report the
+// outcome of each query.
+func InputContextRunner(runner ContextRunner) Option {
 	return func(o *Options) {
 		o.runner = runner
 	}
@@ -91,25 +123,235 @@ func MaxQPSSearchIterations(maxQpsSearchIterations int64) Option {
 	}
 }
 
+// The offered QPS of the first level of a latency-throughput sweep.
+func SweepStartQPS(qps float64) Option {
+	return func(o *Options) {
+		o.sweepStartQPS = qps
+	}
+}
+
+// The factor by which the offered QPS grows between sweep levels.
+func SweepGrowthFactor(factor float64) Option {
+	return func(o *Options) {
+		o.sweepGrowthFactor = factor
+	}
+}
+
+// The maximum number of levels in a sweep, saturated or not.
+func SweepMaxLevels(levels int) Option {
+	return func(o *Options) {
+		o.sweepMaxLevels = levels
+	}
+}
+
+// The latency percentiles reported at every sweep level.
+func SweepPercentiles(percentiles ...float64) Option {
+	return func(o *Options) {
+		o.sweepPercentiles = nil
+		for _, p := range percentiles {
+			if p > 1.0 {
+				p = p / 100.0
+			}
+			o.sweepPercentiles = append(o.sweepPercentiles, p)
+		}
+	}
+}
+
+// Queries issued within this duration from the start of the trace are replayed
+// but excluded from the statistics.
+func Warmup(d time.Duration) Option {
+	return func(o *Options) {
+		o.warmup = d
+	}
+}
+
+// The first n queries of the trace are replayed but excluded from the
+// statistics.
+func WarmupQueries(n int) Option {
+	return func(o *Options) {
+		o.warmupQueries = n
+	}
+}
+
+// Queries issued within this duration from the end of the trace are replayed
+// but excluded from the statistics.
+func Cooldown(d time.Duration) Option {
+	return func(o *Options) {
+		o.cooldown = d
+	}
+}
+
+// The last n queries of the trace are replayed but excluded from the
+// statistics.
+func CooldownQueries(n int) Option {
+	return func(o *Options) {
+		o.cooldownQueries = n
+	}
+}
+
+// Duration of an untimed warm-up trace replayed at the same QPS before the
+// timed trace.
+func WarmupPhase(d time.Duration) Option {
+	return func(o *Options) {
+		o.warmupPhase = d
+	}
+}
+
+// The number of clients issuing queries back to back in closed-loop mode.
+func Concurrency(n int) Option {
+	return func(o *Options) {
+		o.concurrency = n
+	}
+}
+
+// The largest concurrency tried when searching for the best concurrency.
+func MaxConcurrency(n int) Option {
+	return func(o *Options) {
+		o.maxConcurrency = n
+	}
+}
+
+// The time a closed-loop client waits between a completion and its next query.
+func ThinkTime(d time.Duration) Option {
+	return func(o *Options) {
+		o.thinkTime = d
+	}
+}
+
+// The maximum number of queries in flight during a replay, or 0 for no limit.
+func MaxInFlight(n int) Option {
+	return func(o *Options) {
+		o.maxInFlight = n
+	}
+}
+
+// What to do with queries arriving while MaxInFlight queries are in flight.
+func Overload(policy OverloadPolicy) Option {
+	return func(o *Options) {
+		o.overloadPolicy = policy
+	}
+}
+
+// The capacity of the FIFO used by the OverloadQueue policy.
+func MaxQueueLength(n int) Option {
+	return func(o *Options) {
+		o.maxQueueLength = n
+	}
+}
+
+// The deadline of every query relative to its issue, or 0 for none.
+func QueryTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.queryTimeout = d
+	}
+}
+
+// The memory, in bytes, inputs are generated into before a replay starts, 512
+// MiB by default. Inputs beyond the budget are generated again when they are
+// needed, which adds to their latency.
+func InputBudget(bytes int64) Option {
+	return func(o *Options) {
+		o.inputBudget = bytes
+	}
+}
+
+// Mixes the classes of the workload. Generated traces tag every query with a
+// class, which is served with the class's input generator and runner, and the
+// max QPS search has to meet the latency bound of every class.
+func InputWorkload(w *Workload) Option {
+	return func(o *Options) {
+		o.workload = w
+	}
+}
+
+// The arrival process of generated traces, Poisson by default.
+func Arrivals(process ArrivalProcess) Option {
+	return func(o *Options) {
+		o.arrivals = process
+	}
+}
+
+// The priority of the queries of generated traces, unless their workload class
+// sets one.
+func QueryPriority(priority int) Option {
+	return func(o *Options) {
+		o.queryPriority = priority
+	}
+}
+
+// The deadline of the queries of generated traces relative to their time
+// stamp, unless their workload class sets one, or 0 for none. Unlike the query
+// timeout, queries are not cancelled once past their deadline; runners forward
+// it to the system under test and results report the misses.
+func QueryDeadline(d time.Duration) Option {
+	return func(o *Options) {
+		o.queryDeadline = d
+	}
+}
+
+// The controller adjusting the rate of RunController, PID(0.2, 0.02, 0) by
+// default.
+func Controller(controller RateController) Option {
+	return func(o *Options) {
+		o.controller = controller
+	}
+}
+
+// How often RunController adjusts the rate, every second by default.
+func ControlInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.controlInterval = d
+	}
+}
+
+// Replays traces in virtual time. Every runner must implement SimulatedRunner,
+// and latencies are measured on the simulator's clock.
+func VirtualTime() Option {
+	return func(o *Options) {
+		o.virtualTime = true
+	}
+}
+
+// The default input generator, alternating between the embedded images.
+func fixtureInput(idx int) ([]byte, error) {
+	if idx%2 == 0 {
+		return ReadFile("/cat.jpg")
+	}
+	return ReadFile("/chicken.jpg")
+}
+
 func NewOptions(opts ...Option) *Options {
 	options := &Options{
-		ctx: context.Background(),
-		inputGenerator: func(idx int) ([]byte, error) {
-			if idx%2 == 0 {
-				ReadFile("/cat.jpg")
-			}
-			return ReadFile("/chicken.jpg")
-		},
+		ctx:                    context.Background(),
+		inputGenerator:         fixtureInput,
 		seed:                   0, //time.Now().UnixNano(),
 		latencyBound:           100 * time.Millisecond,
 		latencyBoundPercentile: 0.99,
 		minDuration:            1 * time.Second,
 		minQueries:             1024,
-		runner:                 SleepingRunner{},
+		runner:                 AdaptRunner(SleepingRunner{}),
 		maxQpsSearchIterations: math.MaxInt64,
+		sweepStartQPS:          64,
+		sweepGrowthFactor:      1.5,
+		sweepMaxLevels:         16,
+		sweepPercentiles:       []float64{0.5, 0.9, 0.95, 0.99},
+		concurrency:            1,
+		maxConcurrency:         1024,
+		overloadPolicy:         OverloadBlock,
+		maxQueueLength:         1024,
+		inputBudget:            512 << 20,
+		arrivals:               PoissonArrivals(),
+		controller:             PID(0.2, 0.02, 0),
+		controlInterval:        time.Second,
 	}
 	for _, o := range opts {
 		o(options)
 	}
+	if options.workload != nil {
+		options.runner = &workloadRunner{
+			workload: options.workload,
+			fallback: options.runner,
+		}
+	}
 	return options
 }
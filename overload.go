@@ -0,0 +1,27 @@
+package synthetic_load
+
+// What Replay does with a query that arrives while MaxInFlight queries are
+// already in flight.
+type OverloadPolicy int
+
+const (
+	// Wait for a slot before issuing the query and record the issue lag.
+	OverloadBlock OverloadPolicy = iota
+	// Drop the query and count it as shed.
+	OverloadShed
+	// Hold the query in a bounded FIFO and shed it if the FIFO is full.
+	OverloadQueue
+)
+
+func (p OverloadPolicy) String() string {
+	switch p {
+	case OverloadBlock:
+		return "block"
+	case OverloadShed:
+		return "shed"
+	case OverloadQueue:
+		return "queue"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,52 @@
+package synthetic_load
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type slowRunner struct{}
+
+func (slowRunner) Run(tr TraceEntry, input []byte, onFinish func()) error {
+	time.Sleep(50 * time.Millisecond)
+	onFinish()
+	return nil
+}
+
+func burstTrace(n int) Trace {
+	trace := Trace{}
+	for ii := 0; ii < n; ii++ {
+		trace = append(trace, TraceEntry{Index: ii})
+	}
+	return trace
+}
+
+func TestOverloadQueue(t *testing.T) {
+	result, err := burstTrace(20).Measure(
+		InputRunner(slowRunner{}),
+		MaxInFlight(2),
+		Overload(OverloadQueue),
+		MaxQueueLength(4),
+		LatencyBoundPercentile(0.5),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 14, result.Shed())
+	assert.Len(t, result.Latencies(), 6)
+	assert.True(t, result.Latencies()[5] >= 150*time.Millisecond)
+	assert.Equal(t, time.Duration(math.MaxInt64), result.Percentile(0.5))
+	assert.NotEmpty(t, result.QueueDepth)
+}
+
+func TestOverloadShedAborts(t *testing.T) {
+	result, err := burstTrace(200).Measure(
+		InputRunner(slowRunner{}),
+		MaxInFlight(2),
+		Overload(OverloadShed),
+	)
+	assert.NoError(t, err)
+	assert.True(t, result.Aborted)
+	assert.Equal(t, 198, result.Shed())
+}
@@ -0,0 +1,98 @@
+package synthetic_load
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/seehuhn/mt19937"
+)
+
+// An input generator producing random payloads whose size in bytes follows a
+// distribution, for benchmarking services bound by transport rather than
+// compute. The payload of an input index only depends on the seed and the
+// index. Replays cache generated inputs within their input budget.
+type PayloadGenerator struct {
+	size         Distribution
+	seed         int64
+	maxSize      int
+	compressible bool
+}
+
+type PayloadOption func(*PayloadGenerator)
+
+// The seed payloads are derived from. Pass the trace's seed for payloads that
+// are reproducible along with the trace.
+func PayloadSeed(seed int64) PayloadOption {
+	return func(g *PayloadGenerator) {
+		g.seed = seed
+	}
+}
+
+// Caps the size of a payload.
+func PayloadMaxSize(n int) PayloadOption {
+	return func(g *PayloadGenerator) {
+		g.maxSize = n
+	}
+}
+
+// Fills payloads with runs drawn from a small alphabet, which compress well,
+// rather than with incompressible random bytes.
+func PayloadCompressible() PayloadOption {
+	return func(g *PayloadGenerator) {
+		g.compressible = true
+	}
+}
+
+// Creates a generator of payloads whose sizes are drawn from the distribution,
+// e.g. Constant(1024) or LogNormal(8, 1).
+func NewPayloadGenerator(size Distribution, opts ...PayloadOption) *PayloadGenerator {
+	g := &PayloadGenerator{
+		size: size,
+	}
+	for _, o := range opts {
+		o(g)
+	}
+	return g
+}
+
+func (g *PayloadGenerator) Generate(idx int) ([]byte, error) {
+	return g.generate(idx), nil
+}
+
+func (g *PayloadGenerator) generate(idx int) []byte {
+	mt := mt19937.New()
+	mt.Seed(int64(mix(uint64(g.seed) ^ mix(uint64(idx)))))
+	rng := rand.New(mt)
+
+	size := int(math.Round(g.size.Sample(rng)))
+	if size < 0 {
+		size = 0
+	}
+	if g.maxSize > 0 && size > g.maxSize {
+		size = g.maxSize
+	}
+
+	payload := make([]byte, size)
+	if !g.compressible {
+		rng.Read(payload)
+		return payload
+	}
+	const alphabet = "abcd"
+	for ii := 0; ii < size; {
+		c := alphabet[rng.Intn(len(alphabet))]
+		for n := 1 + rng.Intn(16); n > 0 && ii < size; n-- {
+			payload[ii] = c
+			ii++
+		}
+	}
+	return payload
+}
+
+// Spreads nearby values, such as consecutive indices, over unrelated seeds
+// (the splitmix64 finalizer).
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
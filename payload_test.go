@@ -0,0 +1,54 @@
+package synthetic_load
+
+import (
+	"bytes"
+	"compress/gzip"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayloadGenerator(t *testing.T) {
+	g := NewPayloadGenerator(Uniform(100, 200), PayloadSeed(3))
+	first, err := g.Generate(7)
+	assert.NoError(t, err)
+	assert.True(t, len(first) >= 100 && len(first) <= 200)
+
+	again, _ := NewPayloadGenerator(Uniform(100, 200), PayloadSeed(3)).Generate(7)
+	assert.Equal(t, first, again)
+	other, _ := NewPayloadGenerator(Uniform(100, 200), PayloadSeed(4)).Generate(7)
+	assert.NotEqual(t, first, other)
+
+	capped, _ := NewPayloadGenerator(Constant(1000), PayloadMaxSize(10)).Generate(0)
+	assert.Len(t, capped, 10)
+}
+
+func TestPayloadCompressible(t *testing.T) {
+	compressedSize := func(opts ...PayloadOption) int {
+		payload, _ := NewPayloadGenerator(Constant(64*1024), opts...).Generate(1)
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(payload)
+		w.Close()
+		return buf.Len()
+	}
+	assert.True(t, compressedSize() > 64*1024)
+	assert.True(t, compressedSize(PayloadCompressible()) < 32*1024)
+}
+
+func TestHistogram(t *testing.T) {
+	d := Histogram([]float64{0, 10, 100}, []float64{3, 1})
+	assert.InDelta(t, 3.0/4*5+1.0/4*55, d.Mean(), 1e-9)
+
+	rng := rand.New(rand.NewSource(1))
+	low := 0
+	for ii := 0; ii < 10000; ii++ {
+		v := d.Sample(rng)
+		assert.True(t, v >= 0 && v <= 100)
+		if v < 10 {
+			low++
+		}
+	}
+	assert.InDelta(t, 7500, low, 300)
+}
@@ -0,0 +1,190 @@
+package synthetic_load
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A query's outcome as logged by a RecordingRunner, one JSON object per line.
+type Recording struct {
+	Index      int           `json:"index"`
+	InputIndex int           `json:"input_index"`
+	TimeStamp  time.Duration `json:"time_stamp"`
+	InputHash  string        `json:"input_hash"`
+	Response   []byte        `json:"response,omitempty"`
+	Latency    time.Duration `json:"latency"`
+	Error      string        `json:"error,omitempty"`
+}
+
+func hashInput(input []byte) string {
+	sum := sha256.Sum256(input)
+	return hex.EncodeToString(sum[:])
+}
+
+// A ContextRunner that passes queries on to another runner and logs every
+// query's trace entry, input hash, response and latency to a writer.
+type RecordingRunner struct {
+	inner ContextRunner
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func NewRecordingRunner(inner ContextRunner, w io.Writer) *RecordingRunner {
+	return &RecordingRunner{
+		inner: inner,
+		enc:   json.NewEncoder(w),
+	}
+}
+
+func (r *RecordingRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	hash := hashInput(q.Input)
+	start := time.Now()
+	// a query is recorded once, also if the inner runner fails to issue it
+	var once sync.Once
+	record := func(c Completion) {
+		once.Do(func() {
+			r.record(q, hash, time.Since(start), c)
+		})
+	}
+	err := r.inner.RunContext(ctx, q, func(c Completion) {
+		record(c)
+		onFinish(c)
+	})
+	if err != nil {
+		record(Completion{Err: err})
+	}
+	return err
+}
+
+func (r *RecordingRunner) record(q Query, hash string, latency time.Duration, c Completion) {
+	recording := Recording{
+		Index:      q.Index,
+		InputIndex: q.InputIndex,
+		TimeStamp:  q.TimeStamp,
+		InputHash:  hash,
+		Response:   c.Response,
+		Latency:    latency,
+	}
+	if c.Err != nil {
+		recording.Error = c.Err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(recording); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *RecordingRunner) Counters() map[string]int64 {
+	return runnerCounters(r.inner)
+}
+
+// The first error writing the log, if any.
+func (r *RecordingRunner) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// A ContextRunner that serves the responses logged by a RecordingRunner after
+// the recorded latencies, so that a captured service can be replayed offline.
+// It also runs in virtual time.
+//
+// A query is matched to the recording of the same trace index if their inputs
+// are the same, and otherwise to the recordings of the same input in turn.
+type ReplayingRunner struct {
+	byIndex map[int]Recording
+
+	mu     sync.Mutex
+	byHash map[string][]Recording
+	next   map[string]int
+}
+
+// Reads the log written by a RecordingRunner.
+func NewReplayingRunner(r io.Reader) (*ReplayingRunner, error) {
+	runner := &ReplayingRunner{
+		byIndex: map[int]Recording{},
+		byHash:  map[string][]Recording{},
+		next:    map[string]int{},
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var recording Recording
+		if err := json.Unmarshal(scanner.Bytes(), &recording); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		runner.byIndex[recording.Index] = recording
+		runner.byHash[recording.InputHash] = append(runner.byHash[recording.InputHash], recording)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return runner, nil
+}
+
+func (r *ReplayingRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	recording, err := r.lookup(q)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-time.After(recording.Latency):
+		onFinish(recording.completion())
+	case <-ctx.Done():
+		onFinish(Completion{Err: ctx.Err()})
+	}
+	return nil
+}
+
+func (r *ReplayingRunner) Simulate(sim *Simulator, q Query, onFinish func(Completion)) {
+	recording, err := r.lookup(q)
+	if err != nil {
+		onFinish(Completion{Err: err})
+		return
+	}
+	sim.After(recording.Latency, func() {
+		onFinish(recording.completion())
+	})
+}
+
+func (r *ReplayingRunner) lookup(q Query) (Recording, error) {
+	hash := hashInput(q.Input)
+	if recording, ok := r.byIndex[q.Index]; ok && recording.InputHash == hash {
+		return recording, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recordings := r.byHash[hash]
+	if len(recordings) == 0 {
+		return Recording{}, fmt.Errorf("no recording of query %d with input hash %s", q.Index, hash)
+	}
+	recording := recordings[r.next[hash]%len(recordings)]
+	r.next[hash]++
+	return recording, nil
+}
+
+func (recording Recording) completion() Completion {
+	c := Completion{Response: recording.Response}
+	if recording.Error != "" {
+		c.Err = errors.New(recording.Error)
+	}
+	return c
+}
@@ -0,0 +1,64 @@
+package synthetic_load
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Echoes the input back after as many milliseconds as the input index.
+type echoRunner struct{}
+
+func (echoRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	time.Sleep(time.Duration(q.InputIndex) * time.Millisecond)
+	onFinish(Completion{Response: q.Input})
+	return nil
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	trace := Trace{}
+	for ii := 0; ii < 8; ii++ {
+		trace = append(trace, TraceEntry{Index: ii, InputIndex: 5 * (ii % 4)})
+	}
+	generator := InputGenerator(func(idx int) ([]byte, error) {
+		return []byte(strconv.Itoa(idx)), nil
+	})
+
+	var recordings bytes.Buffer
+	recorder := NewRecordingRunner(echoRunner{}, &recordings)
+	recorded, err := trace.Measure(InputContextRunner(recorder), generator)
+	assert.NoError(t, err)
+	assert.NoError(t, recorder.Err())
+
+	replayer, err := NewReplayingRunner(&recordings)
+	assert.NoError(t, err)
+	replayed, err := trace.Measure(InputContextRunner(replayer), generator, VirtualTime())
+	assert.NoError(t, err)
+
+	// the replayed latencies are the runner's, without the scheduling delays
+	// the recorded replay adds to them
+	for ii, tr := range trace {
+		latency := replayed.Queries[ii].Latency
+		assert.True(t, latency >= time.Duration(tr.InputIndex)*time.Millisecond, "latency %v", latency)
+		assert.True(t, latency <= recorded.Queries[ii].Latency, "latency %v", latency)
+	}
+
+	err = replayer.RunContext(context.Background(), Query{Input: []byte("10")}, func(c Completion) {
+		assert.Equal(t, "10", string(c.Response))
+	})
+	assert.NoError(t, err)
+	assert.Error(t, replayer.RunContext(context.Background(), Query{Input: []byte("unknown")}, nil))
+
+	// queries the inner runner fails to issue are recorded too
+	var failures bytes.Buffer
+	recorder = NewRecordingRunner(replayer, &failures)
+	assert.Error(t, recorder.RunContext(context.Background(), Query{Input: []byte("unknown")}, nil))
+	var recording Recording
+	assert.NoError(t, json.Unmarshal(failures.Bytes(), &recording))
+	assert.Contains(t, recording.Error, "no recording")
+}
@@ -0,0 +1,301 @@
+package synthetic_load
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// The outcome of a single replayed query.
+type QueryResult struct {
+	TraceEntry
+	// Offset from the start of the replay at which the query was issued.
+	Issued time.Duration
+	// How late the query was issued relative to its trace time stamp.
+	IssueLag time.Duration
+	// Time the query waited for an in-flight slot before reaching the runner.
+	Queued time.Duration
+	// Time between issuing the query and its completion callback.
+	Latency time.Duration
+	// Whether the runner invoked the completion callback.
+	Finished bool
+	// The error returned or reported by the runner, if any.
+	Err error
+	// The latency breakdown reported by the runner, if any.
+	Timings map[string]time.Duration
+	// The endpoint that served the query, if the runner reports it.
+	Target string
+	// Whether the query was dropped because too many queries were in flight.
+	Shed bool
+	// Whether the query fell in a warm-up or cool-down window and is left out
+	// of the statistics.
+	Excluded bool
+}
+
+// The outcome of replaying a trace.
+type ReplayResult struct {
+	Queries []QueryResult
+	// Wall time from the start of the replay until the last query finished.
+	Duration time.Duration
+	// Depth of the overload queue, sampled whenever it changes.
+	QueueDepth []QueueSample
+	// Whether the replay stopped issuing queries once it shed too many of them
+	// to meet the latency bound.
+	Aborted bool
+	// How much the runner's own counters grew during the replay, if it keeps
+	// any.
+	Counters map[string]int64
+}
+
+// The depth of the overload queue at some offset from the start of a replay.
+type QueueSample struct {
+	Time  time.Duration
+	Depth int
+}
+
+// Sorted latencies of all the finished queries.
+func (r *ReplayResult) Latencies() []time.Duration {
+	latencies := make([]time.Duration, 0, len(r.Queries))
+	for _, q := range r.Queries {
+		if q.Finished && !q.Excluded {
+			latencies = append(latencies, q.Latency)
+		}
+	}
+	sort.Slice(latencies, func(ii, jj int) bool {
+		return latencies[ii] < latencies[jj]
+	})
+	return latencies
+}
+
+// The latency below which the given fraction of queries lie. Shed queries
+// never complete and count as slower than any finished query.
+func (r *ReplayResult) Percentile(p float64) time.Duration {
+	latencies := r.Latencies()
+	shed := r.Shed()
+	if shed == 0 {
+		return percentile(latencies, p)
+	}
+	idx := int(math.Ceil(p * float64(len(latencies)+shed-1)))
+	if idx >= len(latencies) {
+		return time.Duration(math.MaxInt64)
+	}
+	return latencies[idx]
+}
+
+// The number of queries dropped by the overload policy.
+func (r *ReplayResult) Shed() int {
+	n := 0
+	for _, q := range r.Queries {
+		if q.Shed && !q.Excluded {
+			n++
+		}
+	}
+	return n
+}
+
+// The latency breakdown entry with the given name below which the given
+// fraction of the queries reporting it lie.
+func (r *ReplayResult) TimingPercentile(name string, p float64) time.Duration {
+	timings := []time.Duration{}
+	for _, q := range r.Queries {
+		if t, ok := q.Timings[name]; ok && !q.Excluded {
+			timings = append(timings, t)
+		}
+	}
+	sort.Slice(timings, func(ii, jj int) bool {
+		return timings[ii] < timings[jj]
+	})
+	return percentile(timings, p)
+}
+
+// The largest delay between a query's trace time stamp and its issue.
+func (r *ReplayResult) MaxIssueLag() time.Duration {
+	lag := time.Duration(0)
+	for _, q := range r.Queries {
+		if !q.Shed && !q.Excluded && q.IssueLag > lag {
+			lag = q.IssueLag
+		}
+	}
+	return lag
+}
+
+// The number of queries that finished without an error.
+func (r *ReplayResult) Completed() int {
+	n := 0
+	for _, q := range r.Queries {
+		if q.Finished && q.Err == nil && !q.Excluded {
+			n++
+		}
+	}
+	return n
+}
+
+// The number of queries that failed.
+func (r *ReplayResult) Errors() int {
+	n := 0
+	for _, q := range r.Queries {
+		if q.Err != nil && !q.Excluded {
+			n++
+		}
+	}
+	return n
+}
+
+// The number of queries counted in the statistics.
+func (r *ReplayResult) Measured() int {
+	n := 0
+	for _, q := range r.Queries {
+		if !q.Excluded {
+			n++
+		}
+	}
+	return n
+}
+
+// The fraction of queries that failed.
+func (r *ReplayResult) ErrorRate() float64 {
+	measured := r.Measured()
+	if measured == 0 {
+		return 0
+	}
+	return float64(r.Errors()) / float64(measured)
+}
+
+// The achieved throughput in successfully completed queries per second,
+// measured over the span between the first and the last completion so that it
+// is comparable to the trace's QPS.
+func (r *ReplayResult) Throughput() float64 {
+	first, last := time.Duration(math.MaxInt64), time.Duration(0)
+	for _, q := range r.Queries {
+		if !q.Finished || q.Err != nil || q.Excluded {
+			continue
+		}
+		end := q.Issued + q.Latency
+		if end < first {
+			first = end
+		}
+		if end > last {
+			last = end
+		}
+	}
+	if last <= first {
+		return 0
+	}
+	return float64(r.Completed()) / (last - first).Seconds()
+}
+
+// Splits the result by the given key, e.g. to report every target on its own.
+// The parts share the duration of the whole replay.
+func (r *ReplayResult) GroupBy(key func(QueryResult) string) map[string]*ReplayResult {
+	groups := map[string]*ReplayResult{}
+	for _, q := range r.Queries {
+		k := key(q)
+		group, ok := groups[k]
+		if !ok {
+			group = &ReplayResult{Duration: r.Duration}
+			groups[k] = group
+		}
+		group.Queries = append(group.Queries, q)
+	}
+	return groups
+}
+
+// The result of every target that served queries.
+func (r *ReplayResult) ByTarget() map[string]*ReplayResult {
+	return r.GroupBy(func(q QueryResult) string {
+		return q.Target
+	})
+}
+
+// A latency percentile over one window of a replay.
+type LatencySample struct {
+	// Offset of the start of the window from the start of the trace.
+	Time    time.Duration
+	Queries int
+	Latency time.Duration
+}
+
+// The given latency percentile of the queries scheduled in consecutive windows
+// of the trace, to show how latency changes over a replay.
+func (r *ReplayResult) LatencyOverTime(window time.Duration, p float64) []LatencySample {
+	windows := map[int64]*ReplayResult{}
+	for _, q := range r.Queries {
+		if q.Excluded {
+			continue
+		}
+		w := int64(q.TimeStamp / window)
+		if windows[w] == nil {
+			windows[w] = &ReplayResult{}
+		}
+		windows[w].Queries = append(windows[w].Queries, q)
+	}
+
+	samples := make([]LatencySample, 0, len(windows))
+	for w, result := range windows {
+		samples = append(samples, LatencySample{
+			Time:    time.Duration(w) * window,
+			Queries: len(result.Queries),
+			Latency: result.Percentile(p),
+		})
+	}
+	sort.Slice(samples, func(ii, jj int) bool {
+		return samples[ii].Time < samples[jj].Time
+	})
+	return samples
+}
+
+// Whether the query has a deadline it did not complete by. Queries that fail or
+// are shed miss their deadline.
+func (q QueryResult) MissedDeadline() bool {
+	if q.Deadline <= 0 {
+		return false
+	}
+	return q.Shed || !q.Finished || q.Err != nil || q.Issued+q.Latency > q.Deadline
+}
+
+// The fraction of the queries with a deadline that missed it.
+func (r *ReplayResult) DeadlineMissRate() float64 {
+	n, missed := 0, 0
+	for _, q := range r.Queries {
+		if q.Deadline <= 0 || q.Excluded {
+			continue
+		}
+		n++
+		if q.MissedDeadline() {
+			missed++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return float64(missed) / float64(n)
+}
+
+// Splits the result by the priority of the queries.
+func (r *ReplayResult) ByPriority() map[int]*ReplayResult {
+	groups := map[int]*ReplayResult{}
+	for _, q := range r.Queries {
+		group, ok := groups[q.Priority]
+		if !ok {
+			group = &ReplayResult{Duration: r.Duration}
+			groups[q.Priority] = group
+		}
+		group.Queries = append(group.Queries, q)
+	}
+	return groups
+}
+
+// Splits the result by the workload class of the queries.
+func (r *ReplayResult) ByClass() map[string]*ReplayResult {
+	return r.GroupBy(func(q QueryResult) string {
+		return q.Class
+	})
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return time.Duration(0)
+	}
+	idx := int(math.Ceil(p * float64(len(sorted)-1)))
+	return sorted[idx]
+}
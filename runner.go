@@ -1,7 +1,88 @@
 package synthetic_load
 
+import (
+	"context"
+	"time"
+)
+
 type Runner interface {
 	// the input is a sequence of bytes and an
 	// on completion function
 	Run(TraceEntry, []byte, func()) error
 }
+
+// A query handed to a ContextRunner.
+type Query struct {
+	TraceEntry
+	Input []byte
+	// The wall time the replay started at, which the trace's time stamps and
+	// deadlines are offsets from. It is zero in virtual time.
+	TraceStart time.Time
+}
+
+// The wall time by which the query must complete, if it has a deadline.
+func (q Query) DeadlineTime() (time.Time, bool) {
+	if q.Deadline <= 0 || q.TraceStart.IsZero() {
+		return time.Time{}, false
+	}
+	return q.TraceStart.Add(q.Deadline), true
+}
+
+// The outcome of a query as reported by a ContextRunner.
+type Completion struct {
+	Response []byte
+	Err      error
+	// Optional breakdown of the query's latency, such as server-side timings.
+	Timings map[string]time.Duration
+	// The endpoint that served the query, for runners spreading queries over
+	// several of them.
+	Target string
+}
+
+type ContextRunner interface {
+	// the context is cancelled with the replay or once the query's deadline
+	// passes, and the on completion function receives the query's outcome
+	RunContext(context.Context, Query, func(Completion)) error
+}
+
+// Wraps a Runner so that it can be used where a ContextRunner is expected.
+// Runners that already implement ContextRunner are returned as is.
+func AdaptRunner(runner Runner) ContextRunner {
+	if r, ok := runner.(ContextRunner); ok {
+		return r
+	}
+	return runnerAdapter{runner: runner}
+}
+
+type runnerAdapter struct {
+	runner Runner
+}
+
+func (a runnerAdapter) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.runner.Run(q.TraceEntry, q.Input, func() {
+		onFinish(Completion{})
+	})
+}
+
+// Implemented by runners that keep counters of their own, such as the number
+// of retries of a wrapper. Replay reports how much each counter grew.
+type CountingRunner interface {
+	Counters() map[string]int64
+}
+
+// The counters of the runner, looking through the adapter of plain Runners.
+func runnerCounters(runner ContextRunner) map[string]int64 {
+	var counting CountingRunner
+	if a, ok := runner.(runnerAdapter); ok {
+		counting, _ = a.runner.(CountingRunner)
+	} else {
+		counting, _ = runner.(CountingRunner)
+	}
+	if counting == nil {
+		return nil
+	}
+	return counting.Counters()
+}
@@ -0,0 +1,61 @@
+package synthetic_load
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type deadlineRunner struct{}
+
+func (deadlineRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	go func() {
+		select {
+		case <-time.After(time.Duration(q.InputIndex) * time.Millisecond):
+			onFinish(Completion{Timings: map[string]time.Duration{"server": time.Millisecond}})
+		case <-ctx.Done():
+			onFinish(Completion{Err: ctx.Err()})
+		}
+	}()
+	return nil
+}
+
+func TestContextRunner(t *testing.T) {
+	trace := Trace{
+		{Index: 0, InputIndex: 1},
+		{Index: 1, InputIndex: 1000},
+	}
+	result, err := trace.Measure(
+		InputContextRunner(deadlineRunner{}),
+		QueryTimeout(50*time.Millisecond),
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, result.Queries[0].Err)
+	assert.Equal(t, context.DeadlineExceeded, result.Queries[1].Err)
+	assert.Equal(t, 1, result.Errors())
+	assert.Equal(t, time.Millisecond, result.TimingPercentile("server", 0.99))
+}
+
+type failingRunner struct{}
+
+func (failingRunner) Run(tr TraceEntry, input []byte, onFinish func()) error {
+	return errors.New("unavailable")
+}
+
+// Calls back and then fails anyway.
+type finishingFailingRunner struct{}
+
+func (finishingFailingRunner) Run(tr TraceEntry, input []byte, onFinish func()) error {
+	onFinish()
+	return errors.New("unavailable")
+}
+
+func TestAdaptRunner(t *testing.T) {
+	result, err := burstTrace(4).Measure(InputRunner(failingRunner{}))
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, result.ErrorRate())
+	assert.Empty(t, result.Latencies())
+}
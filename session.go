@@ -0,0 +1,294 @@
+package synthetic_load
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// One call of a session's script.
+type SessionStep struct {
+	// The name of the step, reported as the class of its queries.
+	Name string
+	// The pause between the completion of the previous call of the session and
+	// the step's call.
+	ThinkTime time.Duration
+	// How many times the step is called in a row given the completion of the
+	// previous call, e.g. once per object found by a detection step, or nil
+	// for once. The completion is empty for the first step.
+	Repeat func(previous Completion) int
+	// The inputs of the step's queries, or nil for the replay's.
+	InputGenerator func(int) ([]byte, error)
+	// The runner of the step's queries, or nil for the replay's.
+	Runner ContextRunner
+}
+
+// The outcome of replaying sessions.
+type SessionResult struct {
+	// Every call of every session, with the name of its step as its class so
+	// that ByClass gives the latencies of each step.
+	Steps *ReplayResult
+	// One query per session, whose latency spans from the issue of the first
+	// call to the completion of the last. A session stops at its first failed
+	// call, whose error it reports.
+	Sessions *ReplayResult
+}
+
+// Replays sessions arriving at the time stamps of the trace. Each session runs
+// the steps in order, every call depending on the completion of the previous
+// one. Sessions are not subject to the in-flight limit.
+func (trace Trace) ReplaySessions(steps []SessionStep, opts ...Option) (*SessionResult, error) {
+	if len(trace) == 0 {
+		return nil, errors.New("empty trace")
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("sessions need at least one step")
+	}
+
+	classes := make([]WorkloadClass, len(steps))
+	for ii, step := range steps {
+		classes[ii] = WorkloadClass{
+			Name:           step.Name,
+			Share:          1,
+			InputGenerator: step.InputGenerator,
+			Runner:         step.Runner,
+		}
+	}
+	workload, err := NewWorkload(classes...)
+	if err != nil {
+		return nil, err
+	}
+	options := NewOptions(append(append([]Option(nil), opts...), InputWorkload(workload))...)
+
+	d := &sessionDriver{
+		options:  options,
+		steps:    steps,
+		trace:    trace,
+		sessions: make([]QueryResult, len(trace)),
+		inputs:   newInputCache(options),
+	}
+	first := make(Trace, len(trace))
+	for ii := range trace {
+		first[ii] = d.entry(ii, 0, steps[0])
+	}
+	if err := d.inputs.prepare(first); err != nil {
+		return nil, err
+	}
+
+	before := runnerCounters(options.runner)
+	if options.virtualTime {
+		err = d.simulate()
+	} else {
+		d.replay()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SessionResult{
+		Steps:    &ReplayResult{Queries: d.queries, Duration: d.duration},
+		Sessions: &ReplayResult{Queries: d.sessions, Duration: d.duration},
+	}
+	if after := runnerCounters(options.runner); after != nil {
+		result.Steps.Counters = map[string]int64{}
+		for name, value := range after {
+			result.Steps.Counters[name] = value - before[name]
+		}
+	}
+	trace.excludeWindows(result.Sessions, options)
+	for ii := range d.queries {
+		d.queries[ii].Excluded = d.sessions[d.querySession[ii]].Excluded
+	}
+
+	return result, nil
+}
+
+type sessionDriver struct {
+	options *Options
+	steps   []SessionStep
+	trace   Trace
+	inputs  *inputCache
+
+	now   func() time.Duration
+	after func(d time.Duration, f func())
+	run   func(q Query, onFinish func(Completion)) error
+	done  func()
+
+	// the wall time the replay started at, zero in virtual time
+	start time.Time
+
+	mu           sync.Mutex
+	sessions     []QueryResult
+	queries      []QueryResult
+	querySession []int
+	duration     time.Duration
+}
+
+// The trace entry of the n-th call of a session. Its input index is derived
+// from the session's so that every call has its own input.
+func (d *sessionDriver) entry(session, n int, step SessionStep) TraceEntry {
+	return TraceEntry{
+		InputIndex: int(mix(uint64(d.trace[session].InputIndex)+uint64(n)) >> 1),
+		Class:      step.Name,
+	}
+}
+
+func (d *sessionDriver) replay() {
+	d.start = time.Now()
+	start := d.start
+	var wg sync.WaitGroup
+	d.now = func() time.Duration {
+		return time.Since(start)
+	}
+	d.after = func(delay time.Duration, f func()) {
+		// the completion callback belongs to the runner, which is not held up
+		// by the think time
+		go func() {
+			select {
+			case <-time.After(delay):
+			case <-d.options.ctx.Done():
+			}
+			f()
+		}()
+	}
+	d.run = func(q Query, onFinish func(Completion)) error {
+		return runQuery(d.options.ctx, d.options, q, onFinish)
+	}
+	d.done = wg.Done
+
+	for ii, tr := range d.trace {
+		d.sessions[ii].TraceEntry = tr
+		if wait := time.Until(start.Add(tr.TimeStamp)); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-d.options.ctx.Done():
+			}
+		}
+		if err := d.options.ctx.Err(); err != nil {
+			d.sessions[ii].Err = err
+			continue
+		}
+		wg.Add(1)
+		go d.begin(ii)
+	}
+	wg.Wait()
+
+	d.duration = d.now()
+}
+
+func (d *sessionDriver) simulate() error {
+	sim := &Simulator{}
+	d.now = sim.Now
+	d.after = sim.After
+	d.run = func(q Query, onFinish func(Completion)) error {
+		return simulateQuery(sim, d.options, q, onFinish)
+	}
+	d.done = func() {}
+
+	for ii, tr := range d.trace {
+		ii := ii
+		d.sessions[ii].TraceEntry = tr
+		sim.At(tr.TimeStamp, func() {
+			d.begin(ii)
+		})
+	}
+	sim.run(func() bool {
+		return d.options.ctx.Err() != nil
+	})
+	if err := d.options.ctx.Err(); err != nil {
+		return err
+	}
+
+	d.duration = d.now()
+	return nil
+}
+
+func (d *sessionDriver) begin(session int) {
+	d.mu.Lock()
+	s := &d.sessions[session]
+	s.Issued = d.now()
+	s.IssueLag = s.Issued - s.TimeStamp
+	d.mu.Unlock()
+
+	d.step(session, 0, 0, Completion{})
+}
+
+// Runs the given step of the session after the previous call completed with
+// the given completion. The session's n-th call is next.
+func (d *sessionDriver) step(session, step, n int, previous Completion) {
+	if step == len(d.steps) {
+		d.finish(session, Completion{})
+		return
+	}
+	count := 1
+	if d.steps[step].Repeat != nil {
+		count = d.steps[step].Repeat(previous)
+	}
+	d.call(session, step, 0, count, n, previous)
+}
+
+// Issues the k-th of count calls of the step.
+func (d *sessionDriver) call(session, step, k, count, n int, previous Completion) {
+	if k >= count {
+		d.step(session, step+1, n, previous)
+		return
+	}
+
+	issue := func() {
+		s := d.steps[step]
+		tr := d.entry(session, n, s)
+		input, err := d.inputs.get(tr)
+		if err != nil {
+			d.finish(session, Completion{Err: err})
+			return
+		}
+
+		d.mu.Lock()
+		ii := len(d.queries)
+		tr.Index = ii
+		tr.TimeStamp = d.now()
+		d.options.schedule(&tr)
+		d.queries = append(d.queries, QueryResult{TraceEntry: tr, Issued: tr.TimeStamp})
+		d.querySession = append(d.querySession, session)
+		d.mu.Unlock()
+
+		err = d.run(Query{TraceEntry: tr, Input: input, TraceStart: d.start}, func(c Completion) {
+			d.mu.Lock()
+			q := &d.queries[ii]
+			q.Latency = d.now() - q.Issued
+			q.Finished = true
+			q.Err = c.Err
+			q.Timings = c.Timings
+			q.Target = c.Target
+			d.mu.Unlock()
+
+			if c.Err != nil {
+				d.finish(session, c)
+				return
+			}
+			d.call(session, step, k+1, count, n+1, c)
+		})
+		if err != nil {
+			d.mu.Lock()
+			d.queries[ii].Err = err
+			d.mu.Unlock()
+			d.finish(session, Completion{Err: err})
+		}
+	}
+
+	if think := d.steps[step].ThinkTime; think > 0 && n > 0 {
+		d.after(think, issue)
+	} else {
+		issue()
+	}
+}
+
+func (d *sessionDriver) finish(session int, c Completion) {
+	d.mu.Lock()
+	s := &d.sessions[session]
+	s.Latency = d.now() - s.Issued
+	s.Finished = true
+	s.Err = c.Err
+	d.mu.Unlock()
+	d.done()
+}
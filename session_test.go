@@ -0,0 +1,87 @@
+package synthetic_load
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaySessions(t *testing.T) {
+	steps := []SessionStep{
+		{
+			Name:   "detect",
+			Runner: NewSimulatedServerRunner(4, Constant(0.010), SimulatedSeed(1)),
+			InputGenerator: func(idx int) ([]byte, error) {
+				return []byte(strconv.Itoa(idx%3 + 1)), nil
+			},
+		},
+		{
+			Name:      "classify",
+			ThinkTime: time.Millisecond,
+			Runner:    NewSimulatedServerRunner(4, Constant(0.002), SimulatedSeed(2)),
+			// one call per crop found by the detection
+			Repeat: func(previous Completion) int {
+				return 2
+			},
+		},
+	}
+
+	trace := NewTrace(QPS(10), MinDuration(10*time.Second), Seed(1))
+	result, err := trace.ReplaySessions(steps, VirtualTime(), QueryPriority(2), QueryDeadline(50*time.Millisecond))
+	assert.NoError(t, err)
+
+	assert.Len(t, result.Sessions.Queries, len(trace))
+	assert.Len(t, result.Steps.Queries, 3*len(trace))
+	byStep := result.Steps.ByClass()
+	assert.Len(t, byStep["detect"].Queries, len(trace))
+	assert.Len(t, byStep["classify"].Queries, 2*len(trace))
+	assert.Equal(t, 10*time.Millisecond, byStep["detect"].Percentile(0.5))
+	assert.Equal(t, 2*time.Millisecond, byStep["classify"].Percentile(0.5))
+	// without queueing, a session takes a detection and two think times and
+	// classifications
+	assert.Equal(t, 16*time.Millisecond, result.Sessions.Percentile(0.5))
+	assert.Equal(t, 0, result.Sessions.Errors())
+	// steps are scheduled like the queries of a trace
+	for _, q := range result.Steps.Queries {
+		assert.Equal(t, 2, q.Priority)
+		assert.Equal(t, q.TimeStamp+50*time.Millisecond, q.Deadline)
+	}
+}
+
+func TestReplaySessionsRealTime(t *testing.T) {
+	steps := []SessionStep{
+		{Name: "first", Runner: AdaptRunner(slowRunner{})},
+		{Name: "second", Runner: AdaptRunner(failingRunner{}), ThinkTime: 10 * time.Millisecond},
+		{Name: "never", Runner: AdaptRunner(slowRunner{})},
+	}
+	trace := Trace{{Index: 0, InputIndex: 1}, {Index: 1, InputIndex: 2, TimeStamp: time.Millisecond}}
+
+	result, err := trace.ReplaySessions(steps, InputGenerator(func(idx int) ([]byte, error) {
+		return nil, nil
+	}))
+	assert.NoError(t, err)
+	assert.Len(t, result.Steps.Queries, 4)
+	assert.Equal(t, 2, result.Sessions.Errors())
+	assert.Nil(t, result.Steps.ByClass()["never"])
+	assert.True(t, result.Sessions.Percentile(0.5) >= 60*time.Millisecond)
+}
+
+func TestReplaySessionsRunnerFinishingAndFailing(t *testing.T) {
+	steps := []SessionStep{
+		{Name: "first", Runner: AdaptRunner(finishingFailingRunner{})},
+		{Name: "second", Runner: AdaptRunner(finishingFailingRunner{})},
+	}
+	trace := Trace{{Index: 0, InputIndex: 1}, {Index: 1, InputIndex: 2, TimeStamp: time.Millisecond}}
+
+	// the queries were issued, so the errors returned after the callbacks are
+	// dropped
+	result, err := trace.ReplaySessions(steps, InputGenerator(func(idx int) ([]byte, error) {
+		return nil, nil
+	}))
+	assert.NoError(t, err)
+	assert.Len(t, result.Steps.Queries, 4)
+	assert.Equal(t, 0, result.Sessions.Errors())
+	assert.Equal(t, 2, result.Sessions.Completed())
+}
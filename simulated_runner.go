@@ -0,0 +1,196 @@
+package synthetic_load
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/seehuhn/mt19937"
+)
+
+// A ContextRunner modelling a server with a fixed number of worker slots and
+// a FIFO queue in front of them. Unlike SleepingRunner, its latency grows with
+// the load, so that replays show queueing and saturation.
+type SimulatedServerRunner struct {
+	workers      int
+	serviceTime  Distribution
+	maxBatch     int
+	marginalCost float64
+	seed         int64
+
+	start   sync.Once
+	mu      sync.Mutex
+	cond    *sync.Cond
+	rng     *rand.Rand
+	queue   []simulatedJob
+	stopped bool
+
+	// the state of the server in virtual time by simulator, guarded by mu and
+	// dropped once the server idles
+	virtual map[*Simulator]*virtualServer
+}
+
+// A server in virtual time, only touched by its simulator.
+type virtualServer struct {
+	busy  int
+	queue []simulatedJob
+}
+
+type SimulatedServerOption func(*SimulatedServerRunner)
+
+// Lets a free worker take up to maxBatch queued queries at once. A batch of n
+// queries takes a service time sample scaled by 1 + (n-1)*marginalCost.
+func SimulatedBatching(maxBatch int, marginalCost float64) SimulatedServerOption {
+	return func(r *SimulatedServerRunner) {
+		r.maxBatch = maxBatch
+		r.marginalCost = marginalCost
+	}
+}
+
+// The seed of the service time samples.
+func SimulatedSeed(seed int64) SimulatedServerOption {
+	return func(r *SimulatedServerRunner) {
+		r.seed = seed
+	}
+}
+
+// Creates a server with the given number of worker slots whose service times,
+// in seconds, follow the given distribution.
+func NewSimulatedServerRunner(workers int, serviceTime Distribution, opts ...SimulatedServerOption) *SimulatedServerRunner {
+	r := &SimulatedServerRunner{
+		workers:     workers,
+		serviceTime: serviceTime,
+		maxBatch:    1,
+	}
+	for _, o := range opts {
+		o(r)
+	}
+
+	mt := mt19937.New()
+	mt.Seed(r.seed)
+	r.rng = rand.New(mt)
+	r.cond = sync.NewCond(&r.mu)
+
+	return r
+}
+
+type simulatedJob struct {
+	arrival  time.Time
+	enqueued time.Duration
+	onFinish func(Completion)
+}
+
+func (r *SimulatedServerRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	r.start.Do(func() {
+		for ii := 0; ii < r.workers; ii++ {
+			go r.work()
+		}
+	})
+
+	r.mu.Lock()
+	r.queue = append(r.queue, simulatedJob{arrival: time.Now(), onFinish: onFinish})
+	r.mu.Unlock()
+	r.cond.Signal()
+
+	return nil
+}
+
+// Stops the workers once the queue drains.
+func (r *SimulatedServerRunner) Close() error {
+	r.mu.Lock()
+	r.stopped = true
+	r.mu.Unlock()
+	r.cond.Broadcast()
+	return nil
+}
+
+func (r *SimulatedServerRunner) work() {
+	for {
+		r.mu.Lock()
+		for len(r.queue) == 0 && !r.stopped {
+			r.cond.Wait()
+		}
+		if len(r.queue) == 0 {
+			r.mu.Unlock()
+			return
+		}
+		n := r.maxBatch
+		if n > len(r.queue) {
+			n = len(r.queue)
+		}
+		batch := append([]simulatedJob(nil), r.queue[:n]...)
+		r.queue = r.queue[n:]
+		serviceTime := r.batchServiceTime(n)
+		r.mu.Unlock()
+
+		dequeued := time.Now()
+		time.Sleep(serviceTime)
+		for _, job := range batch {
+			job.onFinish(Completion{
+				Timings: map[string]time.Duration{
+					"queue":   dequeued.Sub(job.arrival),
+					"service": serviceTime,
+				},
+			})
+		}
+	}
+}
+
+// Samples the service time of a batch of n queries. Must be called with the
+// lock held.
+func (r *SimulatedServerRunner) batchServiceTime(n int) time.Duration {
+	return seconds(r.serviceTime.Sample(r.rng) * (1 + float64(n-1)*r.marginalCost))
+}
+
+func (r *SimulatedServerRunner) Simulate(sim *Simulator, q Query, onFinish func(Completion)) {
+	r.mu.Lock()
+	if r.virtual == nil {
+		r.virtual = map[*Simulator]*virtualServer{}
+	}
+	server := r.virtual[sim]
+	if server == nil {
+		server = &virtualServer{}
+		r.virtual[sim] = server
+	}
+	r.mu.Unlock()
+
+	server.queue = append(server.queue, simulatedJob{enqueued: sim.Now(), onFinish: onFinish})
+	r.dispatch(sim, server)
+}
+
+// Hands queued queries to the free workers in virtual time.
+func (r *SimulatedServerRunner) dispatch(sim *Simulator, server *virtualServer) {
+	for server.busy < r.workers && len(server.queue) > 0 {
+		n := r.maxBatch
+		if n > len(server.queue) {
+			n = len(server.queue)
+		}
+		batch := append([]simulatedJob(nil), server.queue[:n]...)
+		server.queue = server.queue[n:]
+		server.busy++
+
+		r.mu.Lock()
+		serviceTime := r.batchServiceTime(n)
+		r.mu.Unlock()
+
+		dequeued := sim.Now()
+		sim.After(serviceTime, func() {
+			server.busy--
+			if server.busy == 0 && len(server.queue) == 0 {
+				r.mu.Lock()
+				delete(r.virtual, sim)
+				r.mu.Unlock()
+			}
+			for _, job := range batch {
+				job.onFinish(Completion{
+					Timings: map[string]time.Duration{
+						"queue":   dequeued - job.enqueued,
+						"service": serviceTime,
+					},
+				})
+			}
+			r.dispatch(sim, server)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package synthetic_load
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulatedServerRunnerQueues(t *testing.T) {
+	runner := NewSimulatedServerRunner(1, Constant(0.01))
+	defer runner.Close()
+
+	result, err := burstTrace(5).Measure(InputContextRunner(runner))
+	assert.NoError(t, err)
+	latencies := result.Latencies()
+	for ii, latency := range latencies {
+		assert.InDelta(t, float64((ii+1)*10), float64(latency/time.Millisecond), 5)
+	}
+}
+
+func TestSimulatedServerRunnerStatePerSimulator(t *testing.T) {
+	runner := NewSimulatedServerRunner(1, Constant(0.01))
+
+	// a simulation abandoned with the worker busy
+	abandoned := &Simulator{}
+	runner.Simulate(abandoned, Query{}, func(Completion) {})
+
+	sim := &Simulator{}
+	var finished time.Duration
+	runner.Simulate(sim, Query{}, func(c Completion) {
+		finished = sim.Now()
+	})
+	sim.run(func() bool {
+		return false
+	})
+	assert.Equal(t, 10*time.Millisecond, finished)
+	assert.Len(t, runner.virtual, 1)
+}
+
+func TestMMc(t *testing.T) {
+	// an M/M/1 queue has exponential latencies with rate mu - lambda
+	q := MMc{Servers: 1, ArrivalRate: 30, ServiceRate: 50}
+	expected := -math.Log(1-0.99) / 20
+	assert.InDelta(t, expected, q.Percentile(0.99).Seconds(), 1e-6)
+	assert.InDelta(t, 0.05, q.MeanLatency().Seconds(), 1e-6)
+	assert.InDelta(t, 30, q.MaxArrivalRate(seconds(expected), 0.99), 1e-3)
+
+	// more servers wait less at the same utilization
+	assert.True(t, MMc{Servers: 4, ArrivalRate: 120, ServiceRate: 50}.WaitProbability() < q.WaitProbability())
+}
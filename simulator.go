@@ -0,0 +1,92 @@
+package synthetic_load
+
+import (
+	"container/heap"
+	"time"
+)
+
+// A discrete-event scheduler driving a replay in virtual time. Events run one
+// at a time, in time stamp order and, for equal time stamps, in the order they
+// were scheduled, so that a simulated replay is deterministic.
+type Simulator struct {
+	now    time.Duration
+	seq    uint64
+	events eventHeap
+}
+
+// Implemented by runners that can advance a Simulator instead of waiting on
+// the wall clock, which lets Replay run them in virtual time.
+type SimulatedRunner interface {
+	// schedules the query's completion on the simulator
+	Simulate(*Simulator, Query, func(Completion))
+}
+
+// The current virtual time, as an offset from the start of the replay.
+func (s *Simulator) Now() time.Duration {
+	return s.now
+}
+
+// Schedules f to run once the virtual time has advanced by d.
+func (s *Simulator) After(d time.Duration, f func()) {
+	if d < 0 {
+		d = 0
+	}
+	s.At(s.now+d, f)
+}
+
+// Schedules f to run at the virtual time t, or now if t is in the past.
+func (s *Simulator) At(t time.Duration, f func()) {
+	if t < s.now {
+		t = s.now
+	}
+	s.seq++
+	heap.Push(&s.events, event{at: t, seq: s.seq, f: f})
+}
+
+// Runs events until none are left or stop returns true.
+func (s *Simulator) run(stop func() bool) {
+	for s.events.Len() > 0 && !stop() {
+		e := heap.Pop(&s.events).(event)
+		s.now = e.at
+		e.f()
+	}
+}
+
+type event struct {
+	at  time.Duration
+	seq uint64
+	f   func()
+}
+
+type eventHeap []event
+
+func (h eventHeap) Len() int { return len(h) }
+
+func (h eventHeap) Less(ii, jj int) bool {
+	if h[ii].at != h[jj].at {
+		return h[ii].at < h[jj].at
+	}
+	return h[ii].seq < h[jj].seq
+}
+
+func (h eventHeap) Swap(ii, jj int) { h[ii], h[jj] = h[jj], h[ii] }
+
+func (h *eventHeap) Push(x interface{}) { *h = append(*h, x.(event)) }
+
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// Returns the runner as a SimulatedRunner, looking through the adapter of
+// plain Runners.
+func simulatedRunner(runner ContextRunner) (SimulatedRunner, bool) {
+	if a, ok := runner.(runnerAdapter); ok {
+		r, ok := a.runner.(SimulatedRunner)
+		return r, ok
+	}
+	r, ok := runner.(SimulatedRunner)
+	return r, ok
+}
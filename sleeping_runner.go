@@ -13,3 +13,9 @@ func (s SleepingRunner) Run(tr TraceEntry, input []byte, onFinish func()) error
 	onFinish()
 	return nil
 }
+
+func (s SleepingRunner) Simulate(sim *Simulator, q Query, onFinish func(Completion)) {
+	sim.After(20*time.Millisecond, func() {
+		onFinish(Completion{})
+	})
+}
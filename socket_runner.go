@@ -0,0 +1,303 @@
+package synthetic_load
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A ContextRunner that writes every input as a frame over a pool of TCP or
+// Unix-domain connections. Requests are pipelined and each response is
+// matched to its request either by order or by a correlation ID.
+type SocketRunner struct {
+	network       string
+	address       string
+	conns         int
+	framing       Framing
+	correlationID bool
+	dialTimeout   time.Duration
+
+	mu     sync.Mutex
+	pool   []*socketConn
+	next   uint64
+	nextID uint64
+}
+
+type SocketOption func(*SocketRunner)
+
+// The number of connections the queries are spread over round-robin.
+func SocketConns(n int) SocketOption {
+	return func(r *SocketRunner) {
+		r.conns = n
+	}
+}
+
+// How requests and responses are delimited, length-prefixed by default.
+func SocketFraming(framing Framing) SocketOption {
+	return func(r *SocketRunner) {
+		r.framing = framing
+	}
+}
+
+// Prefixes every request frame with a 64-bit big-endian correlation ID and
+// matches responses by the ID they start with, so that the server may answer
+// out of order. Fixed-size frames include the ID.
+func SocketCorrelationID(enabled bool) SocketOption {
+	return func(r *SocketRunner) {
+		r.correlationID = enabled
+	}
+}
+
+// The timeout for establishing a connection.
+func SocketDialTimeout(d time.Duration) SocketOption {
+	return func(r *SocketRunner) {
+		r.dialTimeout = d
+	}
+}
+
+// Creates a SocketRunner for the network ("tcp" or "unix") and address.
+func NewSocketRunner(network, address string, opts ...SocketOption) (*SocketRunner, error) {
+	r := &SocketRunner{
+		network:     network,
+		address:     address,
+		conns:       1,
+		framing:     LengthPrefixFraming{},
+		dialTimeout: 10 * time.Second,
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	if r.conns < 1 {
+		return nil, errors.New("socket runner needs at least one connection")
+	}
+
+	r.pool = make([]*socketConn, r.conns)
+	for ii := range r.pool {
+		conn, err := r.dial()
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.pool[ii] = conn
+	}
+
+	return r, nil
+}
+
+func (r *SocketRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	conn, err := r.conn()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var once sync.Once
+	finished := make(chan struct{})
+	finish := func(response []byte, err error) {
+		once.Do(func() {
+			close(finished)
+			onFinish(Completion{
+				Response: response,
+				Err:      err,
+				Timings:  map[string]time.Duration{"total": time.Since(start)},
+			})
+		})
+	}
+
+	frame := q.Input
+	id := uint64(0)
+	if r.correlationID {
+		id = atomic.AddUint64(&r.nextID, 1)
+		frame = make([]byte, 8+len(q.Input))
+		binary.BigEndian.PutUint64(frame, id)
+		copy(frame[8:], q.Input)
+	}
+
+	if err := conn.send(id, frame, finish); err != nil {
+		return err
+	}
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				// a late response is read and dropped
+				conn.forget(id)
+				finish(nil, ctx.Err())
+			case <-finished:
+			}
+		}()
+	}
+
+	return nil
+}
+
+// Closes all the connections, failing the queries still in flight.
+func (r *SocketRunner) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var err error
+	for _, conn := range r.pool {
+		if conn != nil {
+			if e := conn.conn.Close(); e != nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// Picks the next connection, redialing it if it broke.
+func (r *SocketRunner) conn() (*socketConn, error) {
+	ii := int(atomic.AddUint64(&r.next, 1) % uint64(len(r.pool)))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if conn := r.pool[ii]; conn.broken() {
+		conn, err := r.dial()
+		if err != nil {
+			return nil, err
+		}
+		r.pool[ii] = conn
+	}
+	return r.pool[ii], nil
+}
+
+func (r *SocketRunner) dial() (*socketConn, error) {
+	conn, err := net.DialTimeout(r.network, r.address, r.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &socketConn{
+		conn:     conn,
+		byID:     map[uint64]func([]byte, error){},
+		ordered:  !r.correlationID,
+		lastID:   &r.nextID,
+		framing:  r.framing,
+		reader:   bufio.NewReader(conn),
+		inFlight: []func([]byte, error){},
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// A pipelined connection and the queries waiting for a response on it.
+type socketConn struct {
+	conn    net.Conn
+	framing Framing
+	reader  *bufio.Reader
+	ordered bool
+	// the last correlation ID the runner issued
+	lastID *uint64
+
+	// serializes writes, which may block on a peer that is itself blocked
+	// writing responses, so it must not hold up the read loop
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	err      error
+	inFlight []func([]byte, error)
+	byID     map[uint64]func([]byte, error)
+}
+
+func (c *socketConn) send(id uint64, frame []byte, finish func([]byte, error)) error {
+	// the write lock keeps the write order and the in-flight order in step
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	if c.ordered {
+		c.inFlight = append(c.inFlight, finish)
+	} else {
+		c.byID[id] = finish
+	}
+	c.mu.Unlock()
+
+	if err := c.framing.WriteFrame(c.conn, frame); err != nil {
+		// the read loop fails the query along with the others on the
+		// connection
+		c.conn.Close()
+	}
+	return nil
+}
+
+// Stops waiting for the response to a cancelled query. Responses matched by
+// order keep their place, so only correlated queries are forgotten.
+func (c *socketConn) forget(id uint64) {
+	if c.ordered {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.byID, id)
+}
+
+func (c *socketConn) broken() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err != nil
+}
+
+func (c *socketConn) readLoop() {
+	for {
+		frame, err := c.framing.ReadFrame(c.reader)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.mu.Lock()
+		var finish func([]byte, error)
+		if c.ordered {
+			if len(c.inFlight) > 0 {
+				finish = c.inFlight[0]
+				c.inFlight = c.inFlight[1:]
+			}
+		} else if len(frame) >= 8 {
+			id := binary.BigEndian.Uint64(frame)
+			if id > 0 && id <= atomic.LoadUint64(c.lastID) {
+				finish = c.byID[id]
+				if finish == nil {
+					// the late response to a forgotten query
+					finish = func([]byte, error) {}
+				}
+			}
+			delete(c.byID, id)
+			frame = frame[8:]
+		}
+		c.mu.Unlock()
+
+		if finish == nil {
+			c.conn.Close()
+			c.fail(errors.New("response does not match any request"))
+			return
+		}
+		finish(frame, nil)
+	}
+}
+
+// Fails every query still waiting on the connection.
+func (c *socketConn) fail(err error) {
+	c.mu.Lock()
+	c.err = err
+	pending := c.inFlight
+	for _, finish := range c.byID {
+		pending = append(pending, finish)
+	}
+	c.inFlight = nil
+	c.byID = map[uint64]func([]byte, error){}
+	c.mu.Unlock()
+
+	for _, finish := range pending {
+		finish(nil, err)
+	}
+}
@@ -0,0 +1,180 @@
+package synthetic_load
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Starts a local server that echoes every frame back.
+func startEchoServer(t *testing.T, framing Framing) (string, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					frame, err := framing.ReadFrame(reader)
+					if err != nil {
+						return
+					}
+					if err := framing.WriteFrame(conn, frame); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return lis.Addr().String(), func() { lis.Close() }
+}
+
+func TestSocketRunner(t *testing.T) {
+	for name, tc := range map[string]struct {
+		framing       Framing
+		correlationID bool
+	}{
+		"length-prefix":  {LengthPrefixFraming{}, false},
+		"line":           {LineFraming{}, false},
+		"fixed-size":     {FixedSizeFraming{Size: 12}, true},
+		"correlation-id": {LengthPrefixFraming{}, true},
+	} {
+		addr, stop := startEchoServer(t, tc.framing)
+
+		runner, err := NewSocketRunner(
+			"tcp",
+			addr,
+			SocketConns(2),
+			SocketFraming(tc.framing),
+			SocketCorrelationID(tc.correlationID),
+		)
+		assert.NoError(t, err, name)
+
+		result, err := burstTrace(16).Measure(
+			InputContextRunner(runner),
+			InputGenerator(func(idx int) ([]byte, error) {
+				return []byte(strconv.Itoa(1000 + idx%1000)), nil
+			}),
+		)
+		assert.NoError(t, err, name)
+		assert.Equal(t, 16, result.Completed(), name)
+
+		responses := make(chan Completion, 1)
+		err = runner.RunContext(context.Background(), Query{Input: []byte("1234")}, func(c Completion) {
+			responses <- c
+		})
+		assert.NoError(t, err, name)
+		c := <-responses
+		assert.NoError(t, c.Err, name)
+		assert.Equal(t, "1234", string(c.Response), name)
+
+		assert.NoError(t, runner.Close(), name)
+		stop()
+	}
+
+	_, err := NewSocketRunner("tcp", "127.0.0.1:0", SocketConns(0))
+	assert.Error(t, err)
+}
+
+func TestSocketRunnerLargePipelinedFrames(t *testing.T) {
+	addr, stop := startEchoServer(t, LengthPrefixFraming{})
+	defer stop()
+
+	runner, err := NewSocketRunner("tcp", addr, SocketCorrelationID(true))
+	assert.NoError(t, err)
+	defer runner.Close()
+
+	// far more than the socket buffers hold, so that the server blocks
+	// writing responses while the client is still writing requests
+	input := make([]byte, 4<<20)
+	result, err := burstTrace(16).Measure(
+		InputContextRunner(runner),
+		InputGenerator(func(idx int) ([]byte, error) {
+			return input, nil
+		}),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 16, result.Completed())
+	assert.Equal(t, 0, result.Errors())
+}
+
+func TestSocketRunnerForgetsCancelledQueries(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer lis.Close()
+	// a server that holds back its first response until released
+	release := make(chan struct{})
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		framing := LengthPrefixFraming{}
+		reader := bufio.NewReader(conn)
+		for first := true; ; first = false {
+			frame, err := framing.ReadFrame(reader)
+			if err != nil {
+				return
+			}
+			if first {
+				<-release
+			}
+			framing.WriteFrame(conn, frame)
+		}
+	}()
+
+	runner, err := NewSocketRunner("tcp", lis.Addr().String(), SocketCorrelationID(true))
+	assert.NoError(t, err)
+	defer runner.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan Completion, 1)
+	err = runner.RunContext(ctx, Query{Input: []byte("1234")}, func(c Completion) {
+		done <- c
+	})
+	assert.NoError(t, err)
+	cancel()
+	assert.Equal(t, context.Canceled, (<-done).Err)
+
+	conn := runner.pool[0]
+	conn.mu.Lock()
+	assert.Empty(t, conn.byID)
+	conn.mu.Unlock()
+
+	// the late response is dropped without breaking the connection
+	close(release)
+	err = runner.RunContext(context.Background(), Query{Input: []byte("5678")}, func(c Completion) {
+		done <- c
+	})
+	assert.NoError(t, err)
+	c := <-done
+	assert.NoError(t, c.Err)
+	assert.Equal(t, "5678", string(c.Response))
+}
+
+func TestLengthPrefixFramingMaxSize(t *testing.T) {
+	var buf bytes.Buffer
+	framing := LengthPrefixFraming{MaxSize: 4}
+	assert.NoError(t, framing.WriteFrame(&buf, []byte("1234")))
+	assert.NoError(t, framing.WriteFrame(&buf, []byte("12345")))
+
+	reader := bufio.NewReader(&buf)
+	frame, err := framing.ReadFrame(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", string(frame))
+	_, err = framing.ReadFrame(reader)
+	assert.Error(t, err)
+}
@@ -0,0 +1,133 @@
+package synthetic_load
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// A single level of a latency-throughput sweep.
+type CurvePoint struct {
+	OfferedQPS  float64
+	AchievedQPS float64
+	ErrorRate   float64
+	// Latencies at the curve's percentiles, in the same order.
+	Latencies []time.Duration
+	// Latency at the latency bound percentile.
+	Latency time.Duration
+}
+
+// A latency-throughput curve, ordered by increasing offered QPS.
+type Curve struct {
+	Percentiles []float64
+	Points      []CurvePoint
+	// Index of the point where latency starts growing superlinearly, or -1.
+	KneeIndex int
+}
+
+// The point where latency starts growing superlinearly, or nil if the curve
+// has no knee.
+func (c *Curve) Knee() *CurvePoint {
+	if c.KneeIndex < 0 || c.KneeIndex >= len(c.Points) {
+		return nil
+	}
+	return &c.Points[c.KneeIndex]
+}
+
+// Replays traces at a geometric ladder of QPS levels until the system
+// saturates and returns the resulting latency-throughput curve.
+//
+// A level is saturated when the achieved throughput falls behind the offered
+// load, most queries fail, or the latency at the latency bound percentile
+// exceeds ten times the latency bound, that of any class for a workload. The
+// saturated level is the last point of the curve.
+func SweepCurve(opts ...Option) (*Curve, error) {
+	options := NewOptions(opts...)
+
+	if options.sweepStartQPS <= 0 || options.sweepGrowthFactor <= 1 {
+		return nil, errors.New("sweep needs a positive start qps and a growth factor above one")
+	}
+
+	minAchievedRatio := 0.9
+	maxErrorRate := 0.5
+	maxLatencyFactor := time.Duration(10)
+
+	curve := &Curve{
+		Percentiles: options.sweepPercentiles,
+		KneeIndex:   -1,
+	}
+
+	targetQps := options.sweepStartQPS
+	for level := 0; level < options.sweepMaxLevels; level++ {
+		log.WithField("targetQps", targetQps).Debug("sweeping level")
+
+		options.seed += 1
+		trace := NewTrace(append(opts, Seed(options.seed), QPS(targetQps))...)
+		result, err := trace.Measure(opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		point := CurvePoint{
+			OfferedQPS:  trace.QPS(),
+			AchievedQPS: result.Throughput(),
+			ErrorRate:   result.ErrorRate(),
+			Latency:     result.Percentile(options.latencyBoundPercentile),
+		}
+		for _, p := range curve.Percentiles {
+			point.Latencies = append(point.Latencies, result.Percentile(p))
+		}
+		curve.Points = append(curve.Points, point)
+
+		log.WithField("qps", point.OfferedQPS).
+			WithField("achieved_qps", point.AchievedQPS).
+			WithField("error_rate", point.ErrorRate).
+			WithField("% latency", point.Latency).
+			Info("swept level")
+
+		if point.AchievedQPS < minAchievedRatio*point.OfferedQPS ||
+			point.ErrorRate > maxErrorRate ||
+			!options.withinLatencyBound(result, maxLatencyFactor) {
+			break
+		}
+
+		targetQps *= options.sweepGrowthFactor
+	}
+
+	curve.KneeIndex = findKnee(curve.Points)
+
+	return curve, nil
+}
+
+// Locates the knee of an increasing, convex latency curve as the point
+// furthest below the chord between its end points once both axes are
+// normalized to [0, 1] (the "kneedle" method).
+func findKnee(points []CurvePoint) int {
+	// levels that shed too many queries have no finite latency
+	for len(points) > 0 && points[len(points)-1].Latency == time.Duration(math.MaxInt64) {
+		points = points[:len(points)-1]
+	}
+	if len(points) < 3 {
+		return -1
+	}
+
+	first, last := points[0], points[len(points)-1]
+	qpsRange := last.OfferedQPS - first.OfferedQPS
+	latencyRange := float64(last.Latency - first.Latency)
+	if qpsRange <= 0 || latencyRange <= 0 {
+		return -1
+	}
+
+	knee := -1
+	maxDistance := 0.0
+	for ii := 1; ii < len(points)-1; ii++ {
+		x := (points[ii].OfferedQPS - first.OfferedQPS) / qpsRange
+		y := float64(points[ii].Latency-first.Latency) / latencyRange
+		if distance := x - y; distance > maxDistance {
+			maxDistance = distance
+			knee = ii
+		}
+	}
+
+	return knee
+}
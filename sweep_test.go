@@ -0,0 +1,36 @@
+package synthetic_load
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindKnee(t *testing.T) {
+	points := []CurvePoint{}
+	for ii, latency := range []time.Duration{20, 21, 22, 24, 30, 100, 200} {
+		points = append(points, CurvePoint{
+			OfferedQPS: float64(100 * (ii + 1)),
+			Latency:    latency * time.Millisecond,
+		})
+	}
+	assert.Equal(t, 4, findKnee(points))
+	assert.Equal(t, -1, findKnee(points[:2]))
+}
+
+func TestSweepCurve(t *testing.T) {
+	curve, err := SweepCurve(
+		MinDuration(200*time.Millisecond),
+		MinQueries(16),
+		SweepStartQPS(50),
+		SweepGrowthFactor(2),
+		SweepMaxLevels(3),
+	)
+	assert.NoError(t, err)
+	assert.Len(t, curve.Points, 3)
+	for _, point := range curve.Points {
+		assert.Len(t, point.Latencies, len(curve.Percentiles))
+		assert.True(t, point.Latency >= 20*time.Millisecond)
+	}
+}
@@ -1,11 +1,11 @@
 package synthetic_load
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
 	"math/rand"
-	"sort"
 	"sync"
 	"time"
 
@@ -16,6 +16,13 @@ type TraceEntry struct {
 	Index      int
 	InputIndex int
 	TimeStamp  time.Duration
+	// The workload class of the query, if any.
+	Class string
+	// The scheduling priority of the query, higher being more urgent.
+	Priority int
+	// The offset from the start of the trace by which the query must complete,
+	// or 0 for none.
+	Deadline time.Duration
 }
 
 type Trace []TraceEntry
@@ -23,34 +30,53 @@ type Trace []TraceEntry
 // Generate a trace from a query library based on a seed with a given minimum
 // number of queries, miniumum duration, and qps.
 func NewTrace(opts ...Option) Trace {
-	options := NewOptions(opts...)
+	return newTrace(NewOptions(opts...))
+}
 
+func newTrace(options *Options) Trace {
 	// Using the std::mt19937 pseudo-random number generator ensures a modicum of
 	// cross platform reproducibility for trace generation.
 	mt := mt19937.New()
 	mt.Seed(options.seed)
 	rng := rand.New(mt)
+	// input indices are drawn separately, so that the time stamps of a seed
+	// are the same as before traces picked inputs from it
+	inputRng := inputRng(options.seed)
+
+	var classRng *rand.Rand
+	if options.workload != nil {
+		classRng = options.workload.rng(options.seed)
+	}
 
 	timeStamp := time.Duration(0)
 	tr := []TraceEntry{}
 
 	for timeStamp < options.minDuration || len(tr) < options.minQueries {
-		// Poisson arrival process corresponds to exponentially distributed
-		// interarrival times.
-		// pp.Println(options.minDuration, " === ", time.Duration((rng.ExpFloat64()/options.qps)*float64(time.Second)))
-		timeStamp += time.Duration((rng.ExpFloat64() / options.qps) * float64(time.Second))
+		timeStamp += options.arrivals(rng, options.qps, timeStamp)
 		tr = append(tr,
 			TraceEntry{
 				Index:      len(tr),
-				InputIndex: rand.Int(),
+				InputIndex: inputRng.Int(),
 				TimeStamp:  timeStamp,
 			},
 		)
+		if classRng != nil {
+			tr[len(tr)-1].Class = options.workload.pick(classRng)
+		}
+		options.schedule(&tr[len(tr)-1])
 	}
 
 	return Trace(tr)
 }
 
+// The generator of the input indices of a trace, seeded apart from its
+// arrivals.
+func inputRng(seed int64) *rand.Rand {
+	mt := mt19937.New()
+	mt.Seed(int64(mix(^uint64(seed))))
+	return rand.New(mt)
+}
+
 func (trace Trace) QPS() float64 {
 	traceLength := len(trace)
 	last := trace[traceLength-1]
@@ -64,55 +90,259 @@ func (trace Trace) QPS() float64 {
 func (trace Trace) Replay(opts ...Option) (time.Duration, error) {
 	options := NewOptions(opts...)
 
+	result, err := trace.Measure(opts...)
+	if err != nil {
+		return time.Duration(0), err
+	}
+
+	return result.Percentile(options.latencyBoundPercentile), nil
+}
+
+// Replay a trace like Replay, but return the outcome of every query rather
+// than a single percentile.
+func (trace Trace) Measure(opts ...Option) (*ReplayResult, error) {
+	options := NewOptions(opts...)
+
 	if len(trace) == 0 {
-		return time.Duration(0), errors.New("empty trace")
+		return nil, errors.New("empty trace")
+	}
+
+	replay := Trace.replay
+	if options.virtualTime {
+		replay = Trace.simulate
 	}
 
-	latencies := make([]time.Duration, len(trace))
+	if options.warmupPhase > 0 && len(trace) > 1 {
+		if _, err := replay(trace.warmupTrace(options), options); err != nil {
+			return nil, err
+		}
+	}
+
+	before := runnerCounters(options.runner)
+	result, err := replay(trace, options)
+	if err != nil {
+		return nil, err
+	}
+	if after := runnerCounters(options.runner); after != nil {
+		result.Counters = map[string]int64{}
+		for name, value := range after {
+			result.Counters[name] = value - before[name]
+		}
+	}
+	trace.excludeWindows(result, options)
+
+	return result, nil
+}
+
+func (trace Trace) replay(options *Options) (*ReplayResult, error) {
+	inputs, err := trace.prepareInputs(options)
+	if err != nil {
+		return nil, err
+	}
+
+	queries := make([]QueryResult, len(trace))
+	result := &ReplayResult{Queries: queries}
 	start := time.Now()
 
 	var wg sync.WaitGroup
-	wg.Add(len(trace))
+	// guards the query results, the overload queue and the slots bookkeeping
+	var mu sync.Mutex
+
+	// slots is nil when the number of in-flight queries is unbounded
+	var slots chan struct{}
+	if options.maxInFlight > 0 {
+		slots = make(chan struct{}, options.maxInFlight)
+	}
+	// the queued queries with their inputs
+	type queued struct {
+		ii    int
+		input []byte
+	}
+	queue := []queued{}
+	sampleQueue := func() {
+		result.QueueDepth = append(result.QueueDepth, QueueSample{
+			Time:  time.Since(start),
+			Depth: len(queue),
+		})
+	}
+
+	// Once more queries are shed than the latency bound percentile allows, the
+	// SLO is lost and the remaining queries are not worth issuing.
+	maxShed := int((1 - options.latencyBoundPercentile) * float64(len(trace)))
+	shed := 0
+
+	var issue func(ii int, input []byte, queryStartTime time.Time)
+	finish := func() {
+		if slots == nil {
+			return
+		}
+		mu.Lock()
+		if len(queue) == 0 {
+			<-slots
+			mu.Unlock()
+			return
+		}
+		// hand the slot over to the oldest queued query
+		next := queue[0]
+		queue = queue[1:]
+		sampleQueue()
+		queryStartTime := start.Add(queries[next.ii].Issued)
+		mu.Unlock()
+		go issue(next.ii, next.input, queryStartTime)
+	}
 
-	for ii := range trace {
-		ii := ii
+	issue = func(ii int, input []byte, queryStartTime time.Time) {
 		tr := trace[ii]
-		go func() {
-			defer wg.Done()
-			queryStartTime := start.Add(tr.TimeStamp)
-			_ = queryStartTime
-			time.Sleep(tr.TimeStamp)
-			queryStartTime = time.Now()
-			input, err := options.inputGenerator(tr.InputIndex)
-			if err != nil {
-				log.WithError(err).Panic("unable to generate input")
+
+		// the query is done once the runner either calls back or fails
+		var once sync.Once
+		done := func() {
+			once.Do(func() {
+				finish()
+				wg.Done()
+			})
+		}
+
+		mu.Lock()
+		queries[ii].Queued = time.Since(queryStartTime)
+		mu.Unlock()
+		err := runQuery(
+			options.ctx,
+			options,
+			Query{TraceEntry: tr, Input: input, TraceStart: start},
+			func(c Completion) {
+				mu.Lock()
+				queries[ii].Latency = time.Since(queryStartTime)
+				queries[ii].Finished = true
+				queries[ii].Err = c.Err
+				queries[ii].Timings = c.Timings
+				queries[ii].Target = c.Target
+				mu.Unlock()
+				done()
+			},
+		)
+		if err != nil {
+			mu.Lock()
+			queries[ii].Err = err
+			mu.Unlock()
+			done()
+		}
+	}
+
+	for ii, tr := range trace {
+		queries[ii].TraceEntry = tr
+		if shed > maxShed {
+			queries[ii].Shed = true
+			result.Aborted = true
+			continue
+		}
+
+		// inputs beyond the input budget are generated before the query is
+		// timed
+		input, err := inputs.get(tr)
+		if err != nil {
+			queries[ii].Err = err
+			continue
+		}
+
+		if wait := time.Until(start.Add(tr.TimeStamp)); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-options.ctx.Done():
 			}
-			options.runner.Run(
-				tr,
-				input,
-				func() {
-					latencies[ii] = time.Since(queryStartTime)
-					// fmt.Printf("it took %v to run ii = %v\n", latencies[ii], ii)
-				},
-			)
-		}()
+		}
+		if slots != nil && options.overloadPolicy == OverloadBlock {
+			select {
+			case slots <- struct{}{}:
+			case <-options.ctx.Done():
+			}
+		}
+		if err := options.ctx.Err(); err != nil {
+			// the replay was cancelled, the remaining queries are never issued
+			queries[ii].Err = err
+			continue
+		}
+		queryStartTime := time.Now()
+
+		mu.Lock()
+		queries[ii].Issued = queryStartTime.Sub(start)
+		queries[ii].IssueLag = queries[ii].Issued - tr.TimeStamp
+		if slots != nil && options.overloadPolicy != OverloadBlock {
+			select {
+			case slots <- struct{}{}:
+			default:
+				if options.overloadPolicy == OverloadQueue && len(queue) < options.maxQueueLength {
+					queue = append(queue, queued{ii: ii, input: input})
+					sampleQueue()
+					wg.Add(1)
+				} else {
+					queries[ii].Shed = true
+					shed++
+				}
+				mu.Unlock()
+				continue
+			}
+		}
+		mu.Unlock()
+
+		wg.Add(1)
+		go issue(ii, input, queryStartTime)
 	}
 
 	wg.Wait()
 
-	sort.Slice(latencies, func(ii, jj int) bool {
-		return latencies[ii] < latencies[jj]
-	})
+	result.Duration = time.Since(start)
 
-	idx := int(math.Ceil(options.latencyBoundPercentile * float64(len(latencies)-1)))
+	return result, nil
+}
 
-	return latencies[idx], nil
+// Issues a query with the replay's context, bounded by the query timeout. The
+// on completion function is called at most once, and not at all if the runner
+// fails to issue the query. A runner that calls back and then fails issued the
+// query, and its error is dropped.
+func runQuery(ctx context.Context, options *Options, q Query, onFinish func(Completion)) error {
+	cancel := func() {}
+	if options.queryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, options.queryTimeout)
+	}
+	var once sync.Once
+	err := options.runner.RunContext(ctx, q, func(c Completion) {
+		once.Do(func() {
+			cancel()
+			onFinish(c)
+		})
+	})
+	if err != nil {
+		failed := false
+		once.Do(func() {
+			cancel()
+			failed = true
+		})
+		if !failed {
+			return nil
+		}
+	}
+	return err
 }
 
 // Returns the maximum throughput (QPS) subject to a latency bound.
 func FindMaxQPS(opts ...Option) float64 {
 	options := NewOptions(opts...)
 
+	return searchMaxQPS(
+		options,
+		func(seed int64, qps float64) Trace {
+			return NewTrace(append(opts, Seed(seed), QPS(qps))...)
+		},
+		func(trace Trace) (*ReplayResult, error) {
+			return trace.Measure(opts...)
+		},
+	)
+}
+
+// Searches for the highest rate of generated traces whose replay meets the
+// latency bound, doubling the rate until it fails and then bisecting.
+func searchMaxQPS(options *Options, newTrace func(seed int64, qps float64) Trace, measure func(Trace) (*ReplayResult, error)) float64 {
 	qpsLowerBound := 0.0
 	qpsUpperBound := math.MaxFloat64
 
@@ -133,14 +363,15 @@ func FindMaxQPS(opts ...Option) float64 {
 		log.WithField("targetQps", targetQps).Debug("creating a new trace")
 
 		options.seed += 1
-		trace := NewTrace(append(opts, Seed(options.seed), QPS(targetQps))...)
+		trace := newTrace(options.seed, targetQps)
 		traceQps := trace.QPS()
 		if qpsLowerBound < traceQps && traceQps < qpsUpperBound {
 			log.Debug("replaying trace")
-			measuredLatency, err := trace.Replay(opts...)
+			result, err := measure(trace)
 			if err != nil {
 				break
 			}
+			measuredLatency := result.Percentile(options.latencyBoundPercentile)
 
 			fmt.Printf("qps = %v, latency_bound_percentile = %v, latency = %v\n",
 				traceQps,
@@ -151,7 +382,7 @@ func FindMaxQPS(opts ...Option) float64 {
 				WithField("latency_bound_percentile", 100*options.latencyBoundPercentile).
 				WithField("% latency", measuredLatency).
 				Info("replayed trace")
-			if measuredLatency > options.latencyBound {
+			if !options.meetsLatencyBound(result) {
 				qpsUpperBound = math.Min(qpsUpperBound, traceQps)
 			} else {
 				qpsLowerBound = math.Max(traceQps, qpsLowerBound)
@@ -0,0 +1,94 @@
+package synthetic_load
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// One of several independent sources of load replayed against the same
+// system, to measure how they interfere.
+type Tenant struct {
+	Name string
+	// The options of the tenant's trace, such as Seed, QPS, Arrivals and
+	// MinDuration, its SLO, given by LatencyBound and LatencyBoundPercentile,
+	// and optionally its own InputGenerator and runner. They are applied on
+	// top of the options shared by all tenants.
+	Options []Option
+}
+
+// Replays the traces of all tenants at the same time. The queries of a tenant
+// are tagged with its name as their class, so that ReplayResult.ByClass splits
+// the result by tenant and LatencyOverTime shows how one tenant's latency
+// reacts to another's load.
+func ReplayTenants(tenants []Tenant, opts ...Option) (*ReplayResult, error) {
+	traces := make([]Trace, len(tenants))
+	for ii, tenant := range tenants {
+		traces[ii] = NewTrace(append(append([]Option(nil), opts...), tenant.Options...)...)
+	}
+	return measureTenants(tenants, traces, opts)
+}
+
+// Returns the highest QPS of the tenant meeting its own SLO while the
+// background tenants replay their fixed load alongside it.
+func FindTenantMaxQPS(tenant Tenant, background []Tenant, opts ...Option) float64 {
+	tenantOpts := append(append([]Option(nil), opts...), tenant.Options...)
+	backgroundTraces := make([]Trace, len(background))
+	for ii, b := range background {
+		backgroundTraces[ii] = NewTrace(append(append([]Option(nil), opts...), b.Options...)...)
+	}
+
+	tenants := append(append([]Tenant(nil), background...), tenant)
+	return searchMaxQPS(
+		NewOptions(tenantOpts...),
+		func(seed int64, qps float64) Trace {
+			return NewTrace(append(tenantOpts, Seed(seed), QPS(qps))...)
+		},
+		func(trace Trace) (*ReplayResult, error) {
+			result, err := measureTenants(tenants, append(backgroundTraces, trace), opts)
+			if err != nil {
+				return nil, err
+			}
+			return result.ByClass()[tenant.Name], nil
+		},
+	)
+}
+
+// Merges the traces of the tenants in time stamp order and replays them, with
+// every tenant served as a class of a workload.
+func measureTenants(tenants []Tenant, traces []Trace, opts []Option) (*ReplayResult, error) {
+	if len(tenants) == 0 {
+		return nil, errors.New("no tenants to replay")
+	}
+
+	classes := make([]WorkloadClass, len(tenants))
+	merged := Trace{}
+	for ii, tenant := range tenants {
+		options := NewOptions(append(append([]Option(nil), opts...), tenant.Options...)...)
+		classes[ii] = WorkloadClass{
+			Name:                   tenant.Name,
+			Share:                  1,
+			InputGenerator:         options.inputGenerator,
+			Runner:                 options.runner,
+			LatencyBound:           options.latencyBound,
+			LatencyBoundPercentile: options.latencyBoundPercentile,
+		}
+		for _, tr := range traces[ii] {
+			tr.Class = tenant.Name
+			merged = append(merged, tr)
+		}
+	}
+	workload, err := NewWorkload(classes...)
+	if err != nil {
+		return nil, fmt.Errorf("tenants: %v", err)
+	}
+
+	sort.SliceStable(merged, func(ii, jj int) bool {
+		return merged[ii].TimeStamp < merged[jj].TimeStamp
+	})
+	for ii := range merged {
+		merged[ii].Index = ii
+	}
+
+	return merged.Measure(append(append([]Option(nil), opts...), InputWorkload(workload))...)
+}
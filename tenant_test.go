@@ -0,0 +1,56 @@
+package synthetic_load
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayTenants(t *testing.T) {
+	runner := NewSimulatedServerRunner(1, Constant(0.005), SimulatedSeed(1))
+	tenants := []Tenant{
+		{Name: "a", Options: []Option{
+			Seed(1),
+			QPS(50),
+			Arrivals(SpikeArrivals(3.5, 4*time.Second, 2*time.Second)),
+		}},
+		{Name: "b", Options: []Option{
+			Seed(2),
+			QPS(50),
+			Arrivals(ConstantArrivals()),
+		}},
+	}
+
+	result, err := ReplayTenants(
+		tenants,
+		VirtualTime(),
+		InputContextRunner(runner),
+		MinDuration(10*time.Second),
+		MinQueries(1),
+	)
+	assert.NoError(t, err)
+	byTenant := result.ByClass()
+	assert.Len(t, byTenant, 2)
+	assert.InDelta(t, 500, len(byTenant["b"].Queries), 2)
+
+	// b's queries only queue behind a's while a spikes
+	overTime := byTenant["b"].LatencyOverTime(time.Second, 0.99)
+	assert.True(t, len(overTime) >= 10)
+	assert.True(t, overTime[5].Latency > 2*overTime[1].Latency, "%v", overTime)
+	assert.True(t, overTime[9].Latency < 2*overTime[1].Latency, "%v", overTime)
+}
+
+func TestFindTenantMaxQPS(t *testing.T) {
+	runner := NewSimulatedServerRunner(1, Constant(0.005), SimulatedSeed(1))
+	qps := FindTenantMaxQPS(
+		Tenant{Name: "b", Options: []Option{LatencyBound(50 * time.Millisecond)}},
+		[]Tenant{{Name: "a", Options: []Option{Seed(100), QPS(100)}}},
+		VirtualTime(),
+		InputContextRunner(runner),
+		MinDuration(time.Minute),
+		MaxQPSSearchIterations(20),
+	)
+	// the server has room for 200 QPS in total
+	assert.True(t, qps > 20 && qps < 100, "qps %v", qps)
+}
@@ -0,0 +1,154 @@
+package synthetic_load
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Replays the trace in virtual time. It mirrors replay, including the
+// in-flight limit and the query timeout, but every wait is an event on a
+// Simulator rather than a sleep.
+func (trace Trace) simulate(options *Options) (*ReplayResult, error) {
+	runner, ok := simulatedRunner(options.runner)
+	if !ok {
+		return nil, errors.New("the runner does not support virtual time")
+	}
+	inputs, err := trace.prepareInputs(options)
+	if err != nil {
+		return nil, err
+	}
+
+	queries := make([]QueryResult, len(trace))
+	result := &ReplayResult{Queries: queries}
+	sim := &Simulator{}
+
+	inFlight := 0
+	// queries waiting for an in-flight slot, in arrival order
+	waiting := []int{}
+	sampleQueue := func() {
+		if options.overloadPolicy == OverloadQueue {
+			result.QueueDepth = append(result.QueueDepth, QueueSample{
+				Time:  sim.Now(),
+				Depth: len(waiting),
+			})
+		}
+	}
+
+	maxShed := int((1 - options.latencyBoundPercentile) * float64(len(trace)))
+	shed := 0
+
+	var issue func(ii int)
+	// hands the in-flight slot over to the oldest waiting query
+	release := func() {
+		inFlight--
+		if len(waiting) > 0 {
+			next := waiting[0]
+			waiting = waiting[1:]
+			sampleQueue()
+			issue(next)
+		}
+	}
+	finish := func(ii int, c Completion) {
+		q := &queries[ii]
+		if q.Finished {
+			// completions after the query timed out are dropped
+			return
+		}
+		q.Latency = sim.Now() - q.Issued
+		q.Finished = true
+		q.Err = c.Err
+		q.Timings = c.Timings
+		q.Target = c.Target
+		release()
+	}
+
+	issue = func(ii int) {
+		inFlight++
+		q := &queries[ii]
+		if options.overloadPolicy == OverloadBlock {
+			// a blocked query is issued late rather than queued
+			q.Issued = sim.Now()
+			q.IssueLag = q.Issued - q.TimeStamp
+		} else {
+			q.Queued = sim.Now() - q.Issued
+		}
+
+		input, err := inputs.get(q.TraceEntry)
+		if err != nil {
+			// the query is never issued, so it has no latency
+			q.Err = err
+			release()
+			return
+		}
+		if options.queryTimeout > 0 {
+			sim.After(options.queryTimeout, func() {
+				finish(ii, Completion{Err: context.DeadlineExceeded})
+			})
+		}
+		runner.Simulate(sim, Query{TraceEntry: q.TraceEntry, Input: input}, func(c Completion) {
+			finish(ii, c)
+		})
+	}
+
+	for ii := range trace {
+		ii := ii
+		queries[ii].TraceEntry = trace[ii]
+		sim.At(trace[ii].TimeStamp, func() {
+			q := &queries[ii]
+			if shed > maxShed {
+				q.Shed = true
+				result.Aborted = true
+				return
+			}
+			q.Issued = sim.Now()
+			if options.maxInFlight <= 0 || inFlight < options.maxInFlight {
+				issue(ii)
+				return
+			}
+			switch {
+			case options.overloadPolicy == OverloadBlock:
+				waiting = append(waiting, ii)
+			case options.overloadPolicy == OverloadQueue && len(waiting) < options.maxQueueLength:
+				waiting = append(waiting, ii)
+				sampleQueue()
+			default:
+				q.Shed = true
+				shed++
+			}
+		})
+	}
+
+	sim.run(func() bool {
+		return options.ctx.Err() != nil
+	})
+	if err := options.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result.Duration = sim.Now()
+
+	return result, nil
+}
+
+// Runs a single query in virtual time, bounded by the query timeout. The on
+// completion function is called at most once.
+func simulateQuery(sim *Simulator, options *Options, q Query, onFinish func(Completion)) error {
+	runner, ok := simulatedRunner(options.runner)
+	if !ok {
+		return errors.New("the runner does not support virtual time")
+	}
+	var once sync.Once
+	finish := func(c Completion) {
+		once.Do(func() {
+			onFinish(c)
+		})
+	}
+	if options.queryTimeout > 0 {
+		sim.After(options.queryTimeout, func() {
+			finish(Completion{Err: context.DeadlineExceeded})
+		})
+	}
+	runner.Simulate(sim, q, finish)
+	return nil
+}
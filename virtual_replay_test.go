@@ -0,0 +1,72 @@
+package synthetic_load
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVirtualTimeMatchesMMc(t *testing.T) {
+	serviceRate := 100.0
+	runner := NewSimulatedServerRunner(4, Exponential(1/serviceRate), SimulatedSeed(1))
+
+	start := time.Now()
+	qps := FindMaxQPS(
+		VirtualTime(),
+		InputContextRunner(runner),
+		InputGenerator(func(idx int) ([]byte, error) {
+			return nil, nil
+		}),
+		LatencyBound(100*time.Millisecond),
+		LatencyBoundPercentile(0.99),
+		MinDuration(30*time.Second),
+		MaxQPSSearchIterations(10),
+	)
+	assert.True(t, time.Since(start) < 10*time.Second)
+
+	expected := MMc{Servers: 4, ServiceRate: serviceRate}.MaxArrivalRate(100*time.Millisecond, 0.99)
+	assert.InEpsilon(t, expected, qps, 0.1)
+}
+
+func TestVirtualTimeIsDeterministic(t *testing.T) {
+	trace := NewTrace(QPS(1000), MinDuration(10*time.Second), Seed(3))
+	measure := func() time.Duration {
+		latency, err := trace.Replay(
+			VirtualTime(),
+			InputContextRunner(NewSimulatedServerRunner(2, Exponential(0.001), SimulatedSeed(7))),
+		)
+		assert.NoError(t, err)
+		return latency
+	}
+	assert.Equal(t, measure(), measure())
+}
+
+func TestVirtualTimeInputErrors(t *testing.T) {
+	trace := Trace{
+		{Index: 0, InputIndex: 0},
+		{Index: 1, InputIndex: 1, TimeStamp: time.Millisecond},
+		{Index: 2, InputIndex: 2, TimeStamp: 2 * time.Millisecond},
+	}
+	// the inputs are over budget, so they are generated as the queries are
+	// issued, and the odd ones fail
+	result, err := trace.Measure(
+		VirtualTime(),
+		InputContextRunner(NewSimulatedServerRunner(1, Constant(0.010), SimulatedSeed(1))),
+		InputGenerator(func(idx int) ([]byte, error) {
+			if idx%2 == 1 {
+				return nil, errors.New("no such input")
+			}
+			return []byte("input"), nil
+		}),
+		InputBudget(1),
+		MaxInFlight(1),
+		Overload(OverloadQueue),
+	)
+	assert.NoError(t, err)
+	assert.Error(t, result.Queries[1].Err)
+	assert.False(t, result.Queries[1].Finished)
+	// the failed query hands its slot over to the next one
+	assert.Equal(t, []time.Duration{10 * time.Millisecond, 18 * time.Millisecond}, result.Latencies())
+}
@@ -0,0 +1,35 @@
+package synthetic_load
+
+// Marks the queries that fall in the warm-up or cool-down windows so that they
+// are left out of the statistics.
+func (trace Trace) excludeWindows(result *ReplayResult, options *Options) {
+	if len(trace) == 0 {
+		return
+	}
+
+	first := trace[0].TimeStamp
+	last := trace[len(trace)-1].TimeStamp
+
+	for ii := range result.Queries {
+		q := &result.Queries[ii]
+		if ii < options.warmupQueries || q.TimeStamp-first < options.warmup {
+			q.Excluded = true
+		}
+		if len(trace)-ii <= options.cooldownQueries || last-q.TimeStamp < options.cooldown {
+			q.Excluded = true
+		}
+	}
+}
+
+// Builds the untimed trace replayed before the timed one. It runs at the timed
+// trace's QPS but with a different seed, so that it does not prime the exact
+// inputs of the timed trace. The workload, arrival process and scheduling
+// options carry over.
+func (trace Trace) warmupTrace(options *Options) Trace {
+	warmup := *options
+	warmup.seed = ^options.seed
+	warmup.qps = trace.QPS()
+	warmup.minDuration = options.warmupPhase
+	warmup.minQueries = 1
+	return newTrace(&warmup)
+}
@@ -0,0 +1,68 @@
+package synthetic_load
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExcludeWindows(t *testing.T) {
+	trace := Trace{}
+	result := &ReplayResult{}
+	for ii := 0; ii < 10; ii++ {
+		tr := TraceEntry{Index: ii, TimeStamp: time.Duration(ii) * time.Second}
+		trace = append(trace, tr)
+		result.Queries = append(result.Queries, QueryResult{
+			TraceEntry: tr,
+			Latency:    time.Duration(ii) * time.Millisecond,
+			Finished:   true,
+		})
+	}
+
+	trace.excludeWindows(result, NewOptions(WarmupQueries(2), Cooldown(3*time.Second)))
+
+	assert.Equal(t, 5, result.Measured())
+	assert.Equal(t, 2*time.Millisecond, result.Latencies()[0])
+	assert.Equal(t, 6*time.Millisecond, result.Percentile(1))
+}
+
+// Records the priorities of the queries of a workload class.
+type priorityRecorder struct {
+	mu         sync.Mutex
+	priorities []int
+}
+
+func (r *priorityRecorder) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	r.mu.Lock()
+	r.priorities = append(r.priorities, q.Priority)
+	r.mu.Unlock()
+	onFinish(Completion{})
+	return nil
+}
+
+func TestWarmupFollowsWorkload(t *testing.T) {
+	recorder := &priorityRecorder{}
+	workload, err := NewWorkload(WorkloadClass{
+		Name:  "classify",
+		Share: 1,
+		InputGenerator: func(idx int) ([]byte, error) {
+			return nil, nil
+		},
+		Runner: recorder,
+	})
+	assert.NoError(t, err)
+
+	trace := NewTrace(InputWorkload(workload), QueryPriority(3), QPS(1000), MinQueries(10), MinDuration(0))
+	_, err = trace.Measure(InputWorkload(workload), QueryPriority(3), WarmupPhase(100*time.Millisecond))
+	assert.NoError(t, err)
+
+	// the warm-up queries reach the class runner and are scheduled like the
+	// timed ones
+	assert.True(t, len(recorder.priorities) > len(trace), "%v queries", len(recorder.priorities))
+	for _, priority := range recorder.priorities {
+		assert.Equal(t, 3, priority)
+	}
+}
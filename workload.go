@@ -0,0 +1,222 @@
+package synthetic_load
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/seehuhn/mt19937"
+)
+
+// A type of query in a mixed workload, such as small classification calls
+// next to large detection calls.
+type WorkloadClass struct {
+	Name string
+	// The class's share of the arrivals, relative to the other classes.
+	Share float64
+	// The inputs of the class's queries, or nil for the replay's.
+	InputGenerator func(int) ([]byte, error)
+	// The runner of the class's queries, or nil for the replay's.
+	Runner ContextRunner
+	// The class's SLO, or 0 for the replay's latency bound and latency bound
+	// percentile.
+	LatencyBound           time.Duration
+	LatencyBoundPercentile float64
+	// The priority of the class's queries, or 0 for the replay's.
+	Priority int
+	// The deadline of the class's queries relative to their time stamp, or 0
+	// for the replay's.
+	Deadline time.Duration
+}
+
+// A mix of weighted query classes. Traces generated with InputWorkload tag
+// every entry with a class drawn in proportion to the shares, and the entry is
+// then served with the class's inputs and runner.
+type Workload struct {
+	classes    []WorkloadClass
+	cumulative []float64
+}
+
+func NewWorkload(classes ...WorkloadClass) (*Workload, error) {
+	if len(classes) == 0 {
+		return nil, errors.New("workload needs at least one class")
+	}
+	w := &Workload{}
+	names := map[string]bool{}
+	total := 0.0
+	for _, class := range classes {
+		if names[class.Name] {
+			return nil, fmt.Errorf("duplicate workload class %q", class.Name)
+		}
+		names[class.Name] = true
+		if class.Share <= 0 {
+			return nil, fmt.Errorf("workload class %q needs a positive share", class.Name)
+		}
+		total += class.Share
+		w.classes = append(w.classes, class)
+		w.cumulative = append(w.cumulative, total)
+	}
+	return w, nil
+}
+
+func (w *Workload) Classes() []WorkloadClass {
+	return append([]WorkloadClass(nil), w.classes...)
+}
+
+func (w *Workload) class(name string) *WorkloadClass {
+	for ii := range w.classes {
+		if w.classes[ii].Name == name {
+			return &w.classes[ii]
+		}
+	}
+	return nil
+}
+
+// Draws the class of the next query.
+func (w *Workload) pick(rng *rand.Rand) string {
+	total := w.cumulative[len(w.cumulative)-1]
+	ii := sort.SearchFloat64s(w.cumulative, rng.Float64()*total)
+	if ii >= len(w.classes) {
+		ii = len(w.classes) - 1
+	}
+	return w.classes[ii].Name
+}
+
+// The random number generator classes are drawn with. It is separate from the
+// trace's so that adding a workload does not change arrival times or inputs.
+func (w *Workload) rng(seed int64) *rand.Rand {
+	mt := mt19937.New()
+	mt.Seed(int64(mix(uint64(seed))))
+	return rand.New(mt)
+}
+
+// Serves every query with the runner of its class.
+type workloadRunner struct {
+	workload *Workload
+	fallback ContextRunner
+}
+
+func (r *workloadRunner) runner(class string) ContextRunner {
+	if c := r.workload.class(class); c != nil && c.Runner != nil {
+		return c.Runner
+	}
+	return r.fallback
+}
+
+func (r *workloadRunner) RunContext(ctx context.Context, q Query, onFinish func(Completion)) error {
+	return r.runner(q.Class).RunContext(ctx, q, onFinish)
+}
+
+// Runs the query in virtual time. Classes whose runner does not support
+// virtual time fail their queries.
+func (r *workloadRunner) Simulate(sim *Simulator, q Query, onFinish func(Completion)) {
+	runner, ok := simulatedRunner(r.runner(q.Class))
+	if !ok {
+		onFinish(Completion{Err: fmt.Errorf("the runner of class %q does not support virtual time", q.Class)})
+		return
+	}
+	runner.Simulate(sim, q, onFinish)
+}
+
+func (r *workloadRunner) Counters() map[string]int64 {
+	var counters map[string]int64
+	// classes may share a runner, whose counters are only counted once
+	merged := map[interface{}]bool{}
+	merge := func(runner ContextRunner) {
+		// only pointers are known to be shared, values may not even be
+		// comparable
+		key := interface{}(runner)
+		if a, ok := runner.(runnerAdapter); ok {
+			key = a.runner
+		}
+		if reflect.ValueOf(key).Kind() == reflect.Ptr {
+			if merged[key] {
+				return
+			}
+			merged[key] = true
+		}
+		for name, value := range runnerCounters(runner) {
+			if counters == nil {
+				counters = map[string]int64{}
+			}
+			counters[name] += value
+		}
+	}
+	merge(r.fallback)
+	for _, class := range r.workload.classes {
+		if class.Runner != nil {
+			merge(class.Runner)
+		}
+	}
+	return counters
+}
+
+// The input generator of the entry's class.
+func (o *Options) inputGeneratorFor(tr TraceEntry) func(int) ([]byte, error) {
+	if o.workload != nil {
+		if c := o.workload.class(tr.Class); c != nil && c.InputGenerator != nil {
+			return c.InputGenerator
+		}
+	}
+	return o.inputGenerator
+}
+
+// Sets the priority and deadline of a generated entry, from its class if it has
+// them.
+func (o *Options) schedule(tr *TraceEntry) {
+	priority, deadline := o.queryPriority, o.queryDeadline
+	if o.workload != nil {
+		if c := o.workload.class(tr.Class); c != nil {
+			if c.Priority != 0 {
+				priority = c.Priority
+			}
+			if c.Deadline > 0 {
+				deadline = c.Deadline
+			}
+		}
+	}
+	tr.Priority = priority
+	if deadline > 0 {
+		tr.Deadline = tr.TimeStamp + deadline
+	}
+}
+
+// The latency bound and latency bound percentile of the class.
+func (o *Options) latencyBoundFor(class string) (time.Duration, float64) {
+	bound, p := o.latencyBound, o.latencyBoundPercentile
+	if o.workload != nil {
+		if c := o.workload.class(class); c != nil {
+			if c.LatencyBound > 0 {
+				bound = c.LatencyBound
+			}
+			if c.LatencyBoundPercentile > 0 {
+				p = c.LatencyBoundPercentile
+			}
+		}
+	}
+	return bound, p
+}
+
+// Whether the replay met the latency bound, that of every class for a
+// workload.
+func (o *Options) meetsLatencyBound(result *ReplayResult) bool {
+	return o.withinLatencyBound(result, 1)
+}
+
+// Whether the replay stayed within factor times the latency bound, that of
+// every class for a workload.
+func (o *Options) withinLatencyBound(result *ReplayResult, factor time.Duration) bool {
+	if o.workload == nil {
+		return result.Percentile(o.latencyBoundPercentile) <= factor*o.latencyBound
+	}
+	for class, r := range result.ByClass() {
+		if bound, p := o.latencyBoundFor(class); r.Percentile(p) > factor*bound {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package synthetic_load
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkload(t *testing.T) {
+	workload, err := NewWorkload(
+		WorkloadClass{
+			Name:  "classify",
+			Share: 3,
+			InputGenerator: func(idx int) ([]byte, error) {
+				return []byte("small"), nil
+			},
+			Runner: NewSimulatedServerRunner(1, Constant(0.001), SimulatedSeed(1)),
+		},
+		WorkloadClass{
+			Name:  "detect",
+			Share: 1,
+			InputGenerator: func(idx int) ([]byte, error) {
+				return []byte("large"), nil
+			},
+			Runner:       NewSimulatedServerRunner(1, Constant(0.010), SimulatedSeed(2)),
+			LatencyBound: 50 * time.Millisecond,
+		},
+	)
+	assert.NoError(t, err)
+
+	trace := NewTrace(InputWorkload(workload), QPS(100), MinDuration(10*time.Second), Seed(4))
+	assert.Equal(t, NewTrace(QPS(100), MinDuration(10*time.Second), Seed(4))[10].TimeStamp, trace[10].TimeStamp)
+
+	result, err := trace.Measure(InputWorkload(workload), VirtualTime())
+	assert.NoError(t, err)
+	byClass := result.ByClass()
+	assert.Len(t, byClass, 2)
+	assert.InDelta(t, 0.75, float64(len(byClass["classify"].Queries))/float64(len(trace)), 0.05)
+	assert.Equal(t, time.Millisecond, byClass["classify"].Percentile(0.5))
+	assert.Equal(t, 10*time.Millisecond, byClass["detect"].Percentile(0.5))
+
+	// the detection server saturates at 4 * 100 QPS, well before the
+	// classification server does
+	qps := FindMaxQPS(
+		InputWorkload(workload),
+		VirtualTime(),
+		LatencyBound(10*time.Millisecond),
+		MinDuration(time.Minute),
+		MaxQPSSearchIterations(20),
+	)
+	assert.True(t, qps > 100 && qps < 400, "qps %v", qps)
+
+	_, err = NewWorkload(WorkloadClass{Name: "a", Share: 1}, WorkloadClass{Name: "a", Share: 1})
+	assert.Error(t, err)
+}
+
+func TestDeadlineMissRateByPriority(t *testing.T) {
+	// a single server shared by both classes
+	runner := NewSimulatedServerRunner(1, Constant(0.008), SimulatedSeed(1))
+	workload, err := NewWorkload(
+		WorkloadClass{Name: "interactive", Share: 1, Priority: 1, Deadline: 20 * time.Millisecond},
+		WorkloadClass{Name: "batch", Share: 1, Deadline: time.Second},
+	)
+	assert.NoError(t, err)
+
+	opts := []Option{InputWorkload(workload), InputContextRunner(runner), QPS(110), MinDuration(10 * time.Second), Seed(2)}
+	trace := NewTrace(opts...)
+	for _, tr := range trace {
+		if tr.Class == "interactive" {
+			assert.Equal(t, 1, tr.Priority)
+			assert.Equal(t, tr.TimeStamp+20*time.Millisecond, tr.Deadline)
+		}
+	}
+
+	result, err := trace.Measure(append(opts, VirtualTime())...)
+	assert.NoError(t, err)
+	byPriority := result.ByPriority()
+	assert.Len(t, byPriority, 2)
+	// the server is busy 88% of the time, so that tight deadlines are often
+	// missed but loose ones hardly ever
+	assert.True(t, byPriority[1].DeadlineMissRate() > 0.1, "%v", byPriority[1].DeadlineMissRate())
+	assert.True(t, byPriority[0].DeadlineMissRate() < 0.01, "%v", byPriority[0].DeadlineMissRate())
+}
+
+func TestWorkloadCountsSharedRunnersOnce(t *testing.T) {
+	shared := Chain(&flakyRunner{}, InjectFaults(0, 0, 1, 0))
+	generator := func(idx int) ([]byte, error) {
+		return nil, nil
+	}
+	workload, err := NewWorkload(
+		WorkloadClass{Name: "a", Share: 1, InputGenerator: generator, Runner: shared},
+		WorkloadClass{Name: "b", Share: 1, InputGenerator: generator, Runner: shared},
+	)
+	assert.NoError(t, err)
+
+	trace := NewTrace(InputWorkload(workload), MinQueries(8), MinDuration(0), QPS(1000))
+	result, err := trace.Measure(InputWorkload(workload), InputContextRunner(shared))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), result.Counters["fault.error"])
+}
+
+// A value runner that is not comparable.
+type sliceRunner struct {
+	urls []string
+}
+
+func (r sliceRunner) Run(tr TraceEntry, input []byte, onFinish func()) error {
+	onFinish()
+	return nil
+}
+
+func TestWorkloadUncomparableRunner(t *testing.T) {
+	runner := AdaptRunner(sliceRunner{urls: []string{"x"}})
+	workload, err := NewWorkload(
+		WorkloadClass{Name: "a", Share: 1, Runner: runner},
+		WorkloadClass{Name: "b", Share: 1},
+	)
+	assert.NoError(t, err)
+
+	trace := NewTrace(InputWorkload(workload), MinQueries(4), MinDuration(0), QPS(1000))
+	result, err := trace.Measure(InputWorkload(workload), InputRunner(sliceRunner{urls: []string{"x"}}))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, result.Completed())
+}